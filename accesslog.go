@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+var logFormat = flag.String(`log-format`, ``, `Access log format. Eg: json (default: human-readable logs only)`)
+
+var requestIDHeader = flag.String(`request-id-header`, ``, `Response header returning the request ID shown in logs. Eg: X-Proxy-Request-Id (default: not sent)`)
+var logLevel = flag.String(`log-level`, `info`, `Log verbosity. info: authenticated users once per client connection; debug: on every request`)
+
+var jsonLogger = log.New(os.Stderr, "", 0)
+
+// logAccept logs the authenticated user of a request, for the first request
+// of a connection unless -log-level is debug. Passwords are never logged.
+func (l *accessLog) logAccept(user string, firstOnConn bool) {
+	if firstOnConn || *logLevel == "debug" {
+		l.logln("Accept:", user, l.Remote)
+	}
+}
+
+type accessLog struct {
+	start    time.Time
+	ID       string `json:"id"`
+	Time     string `json:"time"`
+	Method   string `json:"method"`
+	Host     string `json:"host"`
+	Remote   string `json:"remote"`
+	User     string `json:"user,omitempty"`
+	Cert     string `json:"cert,omitempty"`
+	Status   int    `json:"status"`
+	Block    string `json:"block,omitempty"`
+	Sent     int64  `json:"sent,omitempty"`
+	Received int64  `json:"received,omitempty"`
+	Duration int64  `json:"duration_ms"`
+
+	// trace span, see -otel-endpoint
+	traceID, spanID, parentSpanID string
+	events                        []spanEvent
+}
+
+// newRequestID returns a short random ID to correlate the log lines of a request
+func newRequestID() string {
+	var b [6]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// logln logs v prefixed with the request ID
+func (l *accessLog) logln(v ...any) {
+	logAt(sevInfo, append([]any{"[" + l.ID + "]"}, v...)...)
+}
+
+// warnln is logln at warning severity, eg: for auth failures
+func (l *accessLog) warnln(v ...any) {
+	logAt(sevWarning, append([]any{"[" + l.ID + "]"}, v...)...)
+}
+
+// errorln is logln at error severity, eg: for dial failures
+func (l *accessLog) errorln(v ...any) {
+	logAt(sevError, append([]any{"[" + l.ID + "]"}, v...)...)
+}
+
+func (l *accessLog) write() {
+	if *adminListen != "" {
+		recordStats(l)
+	}
+	if l.spanID != "" {
+		queueSpan(l)
+	}
+	if *logFormat != "json" {
+		return
+	}
+	l.Time = l.start.Format(time.RFC3339)
+	l.Duration = time.Since(l.start).Milliseconds()
+	b, err := json.Marshal(l)
+	if err != nil {
+		log.Println("accessLog:", err)
+		return
+	}
+	jsonLogger.Println(string(b))
+}
+
+// cumulative bytes across all CONNECT tunnels
+var tunnelBytesUp, tunnelBytesDown atomic.Int64
+
+// countReader counts bytes read through it, also adding them to total
+// and recording the time of the last read in active
+type countReader struct {
+	r      io.Reader
+	n      atomic.Int64
+	total  *atomic.Int64
+	active *atomic.Int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	if c.total != nil {
+		c.total.Add(int64(n))
+	}
+	if c.active != nil && n > 0 {
+		c.active.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var accessLogPath = flag.String(`access-log`, ``, `Write the JSON access log to this file instead of the log output, implies -log-format json. SIGHUP reopens it, eg: after logrotate moved it`)
+var accessLogMaxSize = byteSize(0)
+
+func init() {
+	flag.Var(&accessLogMaxSize, `access-log-max-size`, `Rotate -access-log once it would grow past this size, renaming it with a timestamp suffix. Eg: 100m (default: never)`)
+}
+
+// lines of the access log are written out at least this often
+const accessLogFlushInterval = time.Second
+
+// rotatingFile is a buffered append-only file that can be renamed away or reopened between writes
+type rotatingFile struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	w       *bufio.Writer
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens path for appending. Callers hold the lock.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, st.Size()
+	if r.w == nil {
+		r.w = bufio.NewWriterSize(f, 64<<10)
+	} else {
+		r.w.Reset(f)
+	}
+	return nil
+}
+
+// Write appends a whole line, rotating first when it would cross maxSize
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			log.Println("Rotate access log:", err)
+		}
+	}
+	n, err := r.w.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// swap flushes and closes the current file, then opens path anew,
+// after rename moved it away if set. Callers hold the lock.
+// On error lines keep going to the old file.
+func (r *rotatingFile) swap(rename func() error) error {
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	if rename != nil {
+		if err := rename(); err != nil {
+			return err
+		}
+	}
+	old := r.f
+	if err := r.open(); err != nil {
+		r.w.Reset(old)
+		return err
+	}
+	return old.Close()
+}
+
+// rotate renames the file to path.timestamp and starts a new one. Callers hold the lock.
+func (r *rotatingFile) rotate() error {
+	return r.swap(func() error {
+		return os.Rename(r.path, r.path+"."+time.Now().Format("20060102-150405.000"))
+	})
+}
+
+// reopen starts writing to whatever file is at path now
+func (r *rotatingFile) reopen() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.swap(nil)
+}
+
+func (r *rotatingFile) flush() error {
+	r.Lock()
+	defer r.Unlock()
+	return r.w.Flush()
+}
+
+// accessLogFile is the -access-log, nil when the access log goes to the log output
+var accessLogFile *rotatingFile
+
+// setupAccessLog sends JSON access log lines to -access-log, flushed every
+// accessLogFlushInterval and reopened on SIGHUP
+func setupAccessLog() error {
+	f, err := openRotatingFile(*accessLogPath, int64(accessLogMaxSize))
+	if err != nil {
+		return err
+	}
+	accessLogFile = f
+	*logFormat = "json"
+	jsonLogger.SetOutput(f)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		tick := time.NewTicker(accessLogFlushInterval)
+		for {
+			select {
+			case <-hup:
+				if err := f.reopen(); err != nil {
+					log.Println("Reopen access log:", err)
+					continue
+				}
+				log.Println("Reopened access log:", f.path)
+			case <-tick.C:
+				if err := f.flush(); err != nil {
+					log.Println("Flush access log:", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var allowHostsFile = flag.String(`allow-hosts`, ``, `File of allowed destination host patterns. Eg: example.com, *.example.com, 10.0.0.0/8`)
+var denyHostsFile = flag.String(`deny-hosts`, ``, `File of denied destination host patterns (checked before -allow-hosts)`)
+var noPrivate = flag.Bool(`no-private`, false, `Refuse to dial loopback, link-local (cloud metadata), private and other internal addresses, checked after DNS resolution. Not applied behind -r, -upstream or -socks5-upstream`)
+var privateBlockStatus = flag.Int(`private-block-status`, 451, `Status answered to requests refused by -no-private, told apart from other 403s`)
+var blockReasonHeader = flag.Bool(`block-reason-header`, true, `Add X-Block-Reason: private-network to responses refused by -no-private`)
+var connectAllowPorts = flag.String(`connect-allow-ports`, ``, `Ports CONNECT may target (default: all). Eg: 443,8443,9000-9100`)
+var allowMethods = flag.String(`allow-methods`, ``, `HTTP methods clients may use, others get 405 (default: all). Eg: GET,POST,CONNECT`)
+var allowConnect = flag.Bool(`allow-connect`, true, `Allow CONNECT tunnels and SOCKS5 connects, false for HTTP only deployments`)
+var noConnect = flag.Bool(`no-connect`, false, `Only forward plain HTTP, CONNECT requests get 405 and SOCKS5 connects are refused (same as -allow-connect=false)`)
+
+type portRange struct {
+	from, to int
+}
+
+// acl is the destination policy in effect, replaced whole on reload
+type acl struct {
+	connectPorts          []portRange
+	allowHosts, denyHosts *hostList
+	methods               map[string]bool
+	// -allow-connect and not -no-connect
+	connect bool
+}
+
+var destACL atomic.Pointer[acl]
+
+// loadACL builds the policy from -connect-allow-ports, -allow-hosts, -deny-hosts,
+// -allow-methods, -allow-connect and -no-connect
+func loadACL() (*acl, error) {
+	a := &acl{connect: *allowConnect && !*noConnect}
+	var err error
+	if *connectAllowPorts != "" {
+		if a.connectPorts, err = parsePortRanges(*connectAllowPorts); err != nil {
+			return nil, err
+		}
+	}
+	if *allowMethods != "" {
+		a.methods = make(map[string]bool)
+		for _, m := range strings.Split(*allowMethods, ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+				a.methods[m] = true
+			}
+		}
+	}
+	if *allowHostsFile != "" {
+		if a.allowHosts, err = loadHostList(*allowHostsFile); err != nil {
+			return nil, err
+		}
+	}
+	if *denyHostsFile != "" {
+		if a.denyHosts, err = loadHostList(*denyHostsFile); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func parsePortRanges(s string) ([]portRange, error) {
+	var ranges []portRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		f, err := strconv.Atoi(from)
+		if err != nil || f < 1 || f > 65535 {
+			return nil, fmt.Errorf("invalid port: %q", part)
+		}
+		t := f
+		if isRange {
+			t, err = strconv.Atoi(to)
+			if err != nil || t < f || t > 65535 {
+				return nil, fmt.Errorf("invalid port range: %q", part)
+			}
+		}
+		ranges = append(ranges, portRange{f, t})
+	}
+	return ranges, nil
+}
+
+func connectPortAllowed(port string) bool {
+	a := destACL.Load()
+	if a.connectPorts == nil {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return false
+	}
+	for _, r := range a.connectPorts {
+		if p >= r.from && p <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// methodAllowed checks method against -allow-methods and -allow-connect
+func methodAllowed(method string) bool {
+	if method == "CONNECT" && !connectEnabled() {
+		return false
+	}
+	a := destACL.Load()
+	return a.methods == nil || a.methods[method]
+}
+
+func connectEnabled() bool {
+	return destACL.Load().connect
+}
+
+// allowedMethods lists the methods for an Allow header, empty when all are allowed
+func allowedMethods() string {
+	a := destACL.Load()
+	var methods []string
+	for m := range a.methods {
+		if m != "CONNECT" || connectEnabled() {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// hostList matches hostnames by exact name, *.suffix wildcard or CIDR
+type hostList struct {
+	exact    map[string]bool
+	suffixes []string
+	nets     []*net.IPNet
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func (l *hostList) add(pattern string) error {
+	if strings.Contains(pattern, "/") {
+		_, n, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return err
+		}
+		l.nets = append(l.nets, n)
+		return nil
+	}
+	pattern = normalizeHost(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		l.suffixes = append(l.suffixes, pattern[1:])
+		return nil
+	}
+	l.exact[pattern] = true
+	return nil
+}
+
+func (l *hostList) match(hostname string) bool {
+	if ip := net.ParseIP(hostname); ip != nil {
+		for _, n := range l.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	hostname = normalizeHost(hostname)
+	if l.exact[hostname] {
+		return true
+	}
+	for _, suffix := range l.suffixes {
+		if strings.HasSuffix(hostname, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadHostList(name string) (*hostList, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := &hostList{exact: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := l.add(line); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return l, scanner.Err()
+}
+
+// ranges not covered by net.IP's Is* methods
+var internalNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+	// NAT64 local-use, RFC 8215, translated however the network likes
+	mustParseCIDR("64:ff9b:1::/48"),
+}
+
+// NAT64 well-known prefix, RFC 6052, the IPv4 address is in the last 4 bytes
+var nat64Net = mustParseCIDR("64:ff9b::/96")
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// internalIP reports whether ip is loopback, link-local, private or otherwise not public
+func internalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if nat64Net.Contains(ip) {
+		return internalIP(net.IP(ip[12:16]))
+	}
+	return false
+}
+
+// blockedAddrError is returned by dials refused by -no-private
+type blockedAddrError struct {
+	host string
+	ip   net.IP
+}
+
+func (e *blockedAddrError) Error() string {
+	return fmt.Sprintf("blocked internal address %s (%s)", e.ip, e.host)
+}
+
+var metricPrivateBlocked = newCounter("proxy_private_blocked_total", "Requests refused by -no-private")
+
+// blockedPrivate reports whether err is a -no-private refusal, counting it and logging it
+// with its own "SSRF blocked" tag to alert on. al.Block is set for the JSON access log.
+func blockedPrivate(err error, al *accessLog, host string) bool {
+	var blocked *blockedAddrError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	metricPrivateBlocked.Add(1)
+	al.Block = "private-network"
+	al.warnln("SSRF blocked:", host, blocked.ip, "remote:", al.Remote)
+	return true
+}
+
+// failDial answers ctx with the status of err and logs it as what failed for host
+func failDial(ctx *fasthttp.RequestCtx, al *accessLog, what, host string, err error) {
+	ctx.SetStatusCode(dialErrorStatus(err))
+	if blockedPrivate(err, al, host) {
+		if *blockReasonHeader {
+			ctx.Response.Header.Set("X-Block-Reason", al.Block)
+		}
+		return
+	}
+	al.errorln(what, host, err)
+}
+
+// publicIPs drops internal addresses when -no-private is set,
+// failing if none are left
+func publicIPs(host string, ips []net.IP) ([]net.IP, error) {
+	if !*noPrivate {
+		return ips, nil
+	}
+	public := ips[:0:0]
+	for _, ip := range ips {
+		if !internalIP(ip) {
+			public = append(public, ip)
+		}
+	}
+	if len(public) == 0 && len(ips) != 0 {
+		return nil, &blockedAddrError{host: host, ip: ips[0]}
+	}
+	return public, nil
+}
+
+// hostAllowed checks hostname against the deny list, then the allow list
+func hostAllowed(hostname string) (bool, string) {
+	a := destACL.Load()
+	if a.denyHosts != nil && a.denyHosts.match(hostname) {
+		return false, "denied host"
+	}
+	if a.allowHosts != nil && !a.allowHosts.match(hostname) {
+		return false, "host not allowed"
+	}
+	return true, ""
+}
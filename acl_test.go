@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInternalIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"100.64.0.1", true},
+		{"93.184.216.34", false},
+		{"::1", true},
+		{"fe80::1", true},
+		{"2606:2800:220:1::1", false},
+		// NAT64 of 127.0.0.1, 10.0.0.1, 169.254.169.254 and 93.184.216.34
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b::a00:1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::5db8:d822", false},
+		{"64:ff9b:1::5db8:d822", true},
+		{"64:ff9b:1:ffff::1", true},
+	}
+	for _, tt := range tests {
+		if got := internalIP(net.ParseIP(tt.ip)); got != tt.internal {
+			t.Errorf("internalIP(%s) = %v, want %v", tt.ip, got, tt.internal)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var authFile = flag.String(`auth-file`, ``, `File of HTTP proxy credentials, one user:pass (or user:bcrypt-hash) per line, passwords may contain ':'`)
+
+// authSet is the credentials in effect, replaced whole on reload
+type authSet struct {
+	// auths maps a precomputed Proxy-Authorization value to its username
+	auths map[string]string
+	// hashes maps a username to its bcrypt hash
+	hashes map[string][]byte
+	// verified caches Proxy-Authorization values already checked against bcrypt
+	verified sync.Map
+	// limiters maps a username to the limiter shared by all its connections
+	limiters map[string]*rateLimiter
+}
+
+// proxyAuth is nil when no credentials are configured
+var proxyAuth atomic.Pointer[authSet]
+
+func basicAuth(cred string) string {
+	return `Basic ` + base64.StdEncoding.EncodeToString([]byte(cred))
+}
+
+func newAuthSet() *authSet {
+	return &authSet{
+		auths:    make(map[string]string),
+		hashes:   make(map[string][]byte),
+		limiters: make(map[string]*rateLimiter),
+	}
+}
+
+// envCreds are the credentials of $PROXY_CREDS_FILE or $PROXY_CREDS. They are kept out of -u,
+// which a reload resets to the config's value.
+var envCreds string
+
+// proxyCreds returns -u, or the credentials from the environment
+func proxyCreds() string {
+	if *creds != "" {
+		return *creds
+	}
+	return envCreds
+}
+
+// loadAuth builds the credentials from -u and -auth-file, nil if there are none
+func loadAuth() (*authSet, error) {
+	c := proxyCreds()
+	if c == "" && *authFile == "" {
+		return nil, nil
+	}
+	s := newAuthSet()
+	if c != "" {
+		s.add(c)
+	}
+	if *authFile != "" {
+		if err := s.loadFile(*authFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.addLimits(*userLimits); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *authSet) add(cred string) {
+	user, pass, _ := strings.Cut(cred, ":")
+	if strings.HasPrefix(pass, "$2") {
+		s.hashes[user] = []byte(pass)
+		return
+	}
+	s.auths[basicAuth(cred)] = user
+}
+
+// addLimits parses -user-limit
+func (s *authSet) addLimits(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndexByte(entry, '=')
+		if i == -1 {
+			return fmt.Errorf("invalid user limit: %q", entry)
+		}
+		limit, err := strconv.ParseInt(entry[i+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid user limit: %q", entry)
+		}
+		if l := newRateLimiter(limit); l != nil {
+			s.limiters[entry[:i]] = l
+		}
+	}
+	return nil
+}
+
+// authenticate returns the username of a Proxy-Authorization header value
+func authenticate(auth []byte) (string, bool) {
+	s := proxyAuth.Load()
+	if s == nil {
+		return "", false
+	}
+	if user, ok := s.auths[string(auth)]; ok {
+		return user, true
+	}
+	if len(s.hashes) == 0 {
+		return "", false
+	}
+	if user, ok := s.verified.Load(string(auth)); ok {
+		return user.(string), true
+	}
+
+	encoded, ok := bytes.CutPrefix(auth, []byte(`Basic `))
+	if !ok {
+		return "", false
+	}
+	cred, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return "", false
+	}
+	user, pass, ok := bytes.Cut(cred, []byte(":"))
+	if !ok {
+		return "", false
+	}
+	hash, ok := s.hashes[string(user)]
+	if !ok {
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword(hash, pass) != nil {
+		return "", false
+	}
+	s.verified.Store(string(auth), string(user))
+	return string(user), true
+}
+
+func (s *authSet) loadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s.add(line)
+	}
+	return scanner.Err()
+}
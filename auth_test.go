@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+// a password ending in :digits is a password, not a limit
+func TestAuthColonPassword(t *testing.T) {
+	s := newAuthSet()
+	s.add("abc:abc:123")
+	if user, ok := s.auths[basicAuth("abc:abc:123")]; !ok || user != "abc" {
+		t.Fatalf("abc:123 not kept as the password: %v", s.auths)
+	}
+	if l := s.limiters["abc"]; l != nil {
+		t.Fatalf("limit %d parsed from the password", l.rate)
+	}
+}
+
+func TestAddLimits(t *testing.T) {
+	s := newAuthSet()
+	if err := s.addLimits("alice=1048576, bob=65536,carol=0,,d=ve=1"); err != nil {
+		t.Fatal(err)
+	}
+	for user, want := range map[string]int64{"alice": 1048576, "bob": 65536, "d=ve": 1} {
+		if l := s.limiters[user]; l == nil || l.rate != want {
+			t.Errorf("%s: limiter %v, want %d", user, l, want)
+		}
+	}
+	if s.limiters["carol"] != nil {
+		t.Error("carol=0 should be unlimited")
+	}
+
+	for _, spec := range []string{"alice", "alice=", "alice=fast", "alice=1k"} {
+		if err := newAuthSet().addLimits(spec); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"sync"
+	"time"
+)
+
+var authFailLimit = flag.Int(`auth-fail-limit`, 0, `Ban a client IP after this many failed proxy auth attempts within -auth-fail-window (0: never)`)
+var authFailWindow = flag.Duration(`auth-fail-window`, time.Minute, `Window in which failed proxy auth attempts are counted`)
+var authBanDuration = flag.Duration(`auth-ban-duration`, 10*time.Minute, `How long a client IP stays banned after -auth-fail-limit`)
+
+// authFailure counts the failed auth attempts of one client IP
+type authFailure struct {
+	count  int
+	first  time.Time
+	banned bool
+}
+
+var authFails = struct {
+	sync.Mutex
+	m map[string]*authFailure
+}{m: make(map[string]*authFailure)}
+
+// authBanned reports whether ip is banned for failing auth
+func authBanned(ip string) bool {
+	if *authFailLimit <= 0 {
+		return false
+	}
+	authFails.Lock()
+	defer authFails.Unlock()
+	f := authFails.m[ip]
+	return f != nil && f.banned
+}
+
+// recordAuthFailure counts a failed auth attempt of ip and bans it at -auth-fail-limit
+func recordAuthFailure(ip string) {
+	if *authFailLimit <= 0 {
+		return
+	}
+	now := time.Now()
+	authFails.Lock()
+	defer authFails.Unlock()
+	f := authFails.m[ip]
+	if f != nil && f.banned {
+		return
+	}
+	if f == nil || now.Sub(f.first) > *authFailWindow {
+		f = &authFailure{first: now}
+		authFails.m[ip] = f
+	}
+	f.count++
+	if f.count < *authFailLimit {
+		return
+	}
+	f.banned = true
+	log.Println("Ban:", ip, "failed auth:", f.count, "for:", *authBanDuration)
+	time.AfterFunc(*authBanDuration, func() {
+		authFails.Lock()
+		defer authFails.Unlock()
+		if authFails.m[ip] == f {
+			delete(authFails.m, ip)
+		}
+		log.Println("Unban:", ip)
+	})
+}
+
+// expireAuthFailures periodically drops counts older than -auth-fail-window
+func expireAuthFailures(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		authFails.Lock()
+		for ip, f := range authFails.m {
+			if !f.banned && now.Sub(f.first) > *authFailWindow {
+				delete(authFails.m, ip)
+			}
+		}
+		authFails.Unlock()
+	}
+}
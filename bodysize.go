@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var maxBodySize = byteSize(200 << 20)
+
+// also the read buffer of client conns
+var maxHeaderSize = byteSize(8 << 10)
+
+func init() {
+	flag.Var(&maxBodySize, `max-body-size`, `Max request body size, larger requests get 413. Eg: 50m, 2g (default 200m)`)
+	flag.Var(&maxHeaderSize, `max-header-size`, `Max size of a request line and headers, larger requests get 431. Eg: 16k (default 8k)`)
+}
+
+// byteSize is a flag value in bytes, with an optional k, m or g suffix
+type byteSize int64
+
+func (s *byteSize) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+func (s *byteSize) Set(v string) error {
+	num := strings.ToLower(strings.TrimSpace(v))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(num, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(num, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(num, "g"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt/unit {
+		return fmt.Errorf("invalid size: %q", v)
+	}
+	*s = byteSize(n * unit)
+	return nil
+}
+
+// serverErrorHandler answers requests fasthttp failed to read, as its default does
+// but with 413 for bodies over -max-body-size and a logged 431 for headers over -max-header-size
+func serverErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	var smallBuffer *fasthttp.ErrSmallBuffer
+	var netErr *net.OpError
+	switch {
+	case errors.Is(err, fasthttp.ErrBodyTooLarge):
+		ctx.Error("Request body too large", fasthttp.StatusRequestEntityTooLarge)
+	case errors.As(err, &smallBuffer):
+		ctx.Error("Too big request header", fasthttp.StatusRequestHeaderFieldsTooLarge)
+		log.Println("Reject: request header over -max-header-size", ctx.RemoteAddr(), int64(maxHeaderSize))
+	case errors.As(err, &netErr) && netErr.Timeout():
+		ctx.Error("Request timeout", fasthttp.StatusRequestTimeout)
+		rejectSlow(ctx, err)
+	default:
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+	if errorPages != nil {
+		ctx.Response.ResetBody()
+		setErrorPage(&ctx.Response)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"512", 512},
+		{"16k", 16 << 10},
+		{"50m", 50 << 20},
+		{"2g", 2 << 30},
+		{" 2G ", 2 << 30},
+	}
+	for _, tt := range tests {
+		var s byteSize
+		if err := s.Set(tt.in); err != nil || int64(s) != tt.want {
+			t.Errorf("%q: got %d, %v, want %d", tt.in, s, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "-1", "1t", "k", "9999999999999g"} {
+		var s byteSize
+		if err := s.Set(in); err == nil {
+			t.Errorf("%q: got %d, want an error", in, s)
+		}
+	}
+}
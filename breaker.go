@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+var cbThreshold = flag.Int(`cb-threshold`, 0, `Fail requests to a destination fast with 503, skipping the dial, after this many consecutive dial failures to it (0: disabled)`)
+var cbCooldown = flag.Duration(`cb-cooldown`, 30*time.Second, `How long -cb-threshold fails a destination fast before letting one probe dial through`)
+
+// destinations tracked by the circuit breaker, ones not failing enough to open are dropped past this
+const maxCircuits = 10000
+
+var errCircuitOpen = errors.New("circuit open")
+
+// circuit counts the consecutive dial failures of a destination.
+// It is open once they reach the threshold, until a probe dial after the cooldown succeeds.
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var circuits = struct {
+	sync.Mutex
+	m map[string]*circuit
+}{m: make(map[string]*circuit)}
+
+// breakerDial fails dials to an address fast while its circuit is open
+func breakerDial(dial func(network, address string) (net.Conn, error), threshold int, cooldown time.Duration) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		if !circuitAllow(address, threshold) {
+			return nil, fmt.Errorf("%s: %w", address, errCircuitOpen)
+		}
+		c, err := dial(network, address)
+		circuitResult(address, err, threshold, cooldown)
+		return c, err
+	}
+}
+
+// circuitAllow reports whether address may be dialed, letting one probe through once the cooldown is over
+func circuitAllow(address string, threshold int) bool {
+	circuits.Lock()
+	defer circuits.Unlock()
+	c := circuits.m[address]
+	if c == nil || c.failures < threshold {
+		return true
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return false
+	}
+	c.probing = true
+	return true
+}
+
+// circuitResult records the outcome of a dial to address
+func circuitResult(address string, err error, threshold int, cooldown time.Duration) {
+	circuits.Lock()
+	defer circuits.Unlock()
+	c := circuits.m[address]
+	if err == nil {
+		if c != nil && c.failures >= threshold {
+			log.Println("Circuit closed:", address)
+		}
+		delete(circuits.m, address)
+		return
+	}
+	var blocked *blockedAddrError
+	if errors.As(err, &blocked) {
+		// refused by policy, the destination may be fine
+		if c != nil {
+			c.probing = false
+		}
+		return
+	}
+	if c == nil {
+		if len(circuits.m) >= maxCircuits {
+			for a, c := range circuits.m {
+				if c.failures < threshold {
+					delete(circuits.m, a)
+				}
+			}
+		}
+		c = &circuit{}
+		circuits.m[address] = c
+	}
+	c.failures++
+	if c.probing || c.failures == threshold {
+		c.openUntil = time.Now().Add(cooldown)
+		c.probing = false
+		log.Println("Circuit open:", address, "failures:", c.failures, err)
+	}
+}
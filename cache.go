@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var cacheSize = flag.Int64(`cache-size`, 0, `In-memory cache size in bytes for GET responses with explicit freshness (0: no cache)`)
+
+// cacheEntry is a cached response and when it goes stale
+type cacheEntry struct {
+	key     string
+	resp    fasthttp.Response
+	stored  time.Time
+	expires time.Time
+	size    int64
+}
+
+// responseCache is an LRU of responses bounded by total size
+var responseCache = struct {
+	sync.Mutex
+	lru  *list.List
+	m    map[string]*list.Element
+	size int64
+}{lru: list.New(), m: make(map[string]*list.Element)}
+
+// cacheKey keys a request by method and URL, varying on Accept-Encoding
+func cacheKey(ctx *fasthttp.RequestCtx) string {
+	return string(ctx.Method()) + " " + string(ctx.Request.URI().FullURI()) + "\n" + string(ctx.Request.Header.Peek("Accept-Encoding"))
+}
+
+// cacheableRequest reports whether a request may be answered from or stored in the cache
+func cacheableRequest(ctx *fasthttp.RequestCtx) bool {
+	if *cacheSize <= 0 || !ctx.IsGet() || ctx.Request.Header.Peek("Authorization") != nil {
+		return false
+	}
+	cc := ctx.Request.Header.Peek("Cache-Control")
+	return !hasDirective(cc, "no-store") && !hasDirective(cc, "no-cache")
+}
+
+// serveCached answers ctx from the cache, reporting whether it did
+func serveCached(ctx *fasthttp.RequestCtx) bool {
+	key := cacheKey(ctx)
+	now := time.Now()
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	e, ok := responseCache.m[key]
+	if !ok {
+		return false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		removeCached(e)
+		return false
+	}
+	responseCache.lru.MoveToFront(e)
+	entry.resp.CopyTo(&ctx.Response)
+	ctx.Response.Header.Set("Age", strconv.Itoa(int(now.Sub(entry.stored).Seconds())))
+	return true
+}
+
+// storeCached caches the response of ctx if it is fresh for a while
+func storeCached(ctx *fasthttp.RequestCtx) {
+	resp := &ctx.Response
+	switch resp.StatusCode() {
+	case fasthttp.StatusOK, fasthttp.StatusNonAuthoritativeInfo, fasthttp.StatusMovedPermanently,
+		fasthttp.StatusNotFound, fasthttp.StatusGone:
+	default:
+		return
+	}
+	if resp.Header.Peek("Set-Cookie") != nil {
+		return
+	}
+	for _, v := range resp.Header.PeekAll("Vary") {
+		for _, name := range bytes.Split(v, []byte(",")) {
+			if name = bytes.TrimSpace(name); len(name) != 0 && !bytes.EqualFold(name, []byte("Accept-Encoding")) {
+				return
+			}
+		}
+	}
+	now := time.Now()
+	ttl := freshness(&resp.Header, now)
+	if ttl <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{key: cacheKey(ctx), stored: now, expires: now.Add(ttl)}
+	resp.CopyTo(&entry.resp)
+	entry.size = int64(len(entry.resp.Header.Header()) + len(entry.resp.Body()))
+	if entry.size > *cacheSize {
+		return
+	}
+
+	responseCache.Lock()
+	defer responseCache.Unlock()
+	if e, ok := responseCache.m[entry.key]; ok {
+		removeCached(e)
+	}
+	responseCache.m[entry.key] = responseCache.lru.PushFront(entry)
+	responseCache.size += entry.size
+	for responseCache.size > *cacheSize {
+		removeCached(responseCache.lru.Back())
+	}
+}
+
+// removeCached drops e, the cache must be locked
+func removeCached(e *list.Element) {
+	entry := responseCache.lru.Remove(e).(*cacheEntry)
+	delete(responseCache.m, entry.key)
+	responseCache.size -= entry.size
+}
+
+// freshness is how long a response may be served from a shared cache, from
+// Cache-Control s-maxage or max-age, then Expires
+func freshness(h *fasthttp.ResponseHeader, now time.Time) time.Duration {
+	cc := h.Peek("Cache-Control")
+	if hasDirective(cc, "no-store") || hasDirective(cc, "no-cache") || hasDirective(cc, "private") {
+		return 0
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directiveValue(cc, name); ok {
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(secs) * time.Second
+		}
+	}
+	if v := h.Peek("Expires"); v != nil {
+		expires, err := fasthttp.ParseHTTPDate(v)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := fasthttp.ParseHTTPDate(h.Peek("Date")); err == nil {
+			date = d
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+func hasDirective(cc []byte, name string) bool {
+	_, ok := directiveValue(cc, name)
+	return ok
+}
+
+// directiveValue returns the value of a Cache-Control directive
+func directiveValue(cc []byte, name string) (string, bool) {
+	for _, d := range bytes.Split(cc, []byte(",")) {
+		k, v, _ := bytes.Cut(bytes.TrimSpace(d), []byte("="))
+		if bytes.EqualFold(k, []byte(name)) {
+			return string(bytes.Trim(v, `"`)), true
+		}
+	}
+	return "", false
+}
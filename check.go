@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+)
+
+var checkOnly = flag.Bool(`check`, false, `Validate flags, config, certificates, auth and ACL files and listen addresses, then exit without serving`)
+
+// checked logs a validated part of the setup under -check
+func checked(v ...any) {
+	if *checkOnly {
+		log.Println(append([]any{"Check ok:"}, v...)...)
+	}
+}
+
+// checkListen makes sure addr can be listened on
+func checkListen(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Panicln(err)
+	}
+	ln.Close()
+	checked("listen", addr)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+
+	"github.com/valyala/fasthttp"
+)
+
+var compressResponses = flag.Bool(`compress-responses`, false, `Gzip or deflate uncompressed HTTP responses when the client accepts it (not CONNECT)`)
+
+// bodies smaller than this usually grow when compressed
+const minCompressLen = 200
+
+// content types that are compressed already
+var compressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+	"application/octet-stream",
+	"application/pdf",
+	"application/wasm",
+}
+
+func compressible(contentType []byte) bool {
+	if bytes.HasPrefix(contentType, []byte("image/svg")) {
+		return true
+	}
+	for _, t := range compressedTypes {
+		if bytes.HasPrefix(contentType, []byte(t)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressResponse encodes a forwarded response body as the client's Accept-Encoding permits
+func compressResponse(ctx *fasthttp.RequestCtx) {
+	resp := &ctx.Response
+	body := resp.Body()
+	if len(body) < minCompressLen || len(resp.Header.ContentEncoding()) != 0 || !compressible(resp.Header.ContentType()) {
+		return
+	}
+	// a compressed part would not match the byte range of the whole body
+	if resp.StatusCode() == fasthttp.StatusPartialContent || len(resp.Header.Peek("Content-Range")) != 0 {
+		return
+	}
+	var encoded []byte
+	switch {
+	case ctx.Request.Header.HasAcceptEncoding("gzip"):
+		encoded = fasthttp.AppendGzipBytesLevel(nil, body, fasthttp.CompressDefaultCompression)
+		resp.Header.SetContentEncoding("gzip")
+	case ctx.Request.Header.HasAcceptEncoding("deflate"):
+		encoded = fasthttp.AppendDeflateBytesLevel(nil, body, fasthttp.CompressDefaultCompression)
+		resp.Header.SetContentEncoding("deflate")
+	default:
+		return
+	}
+	resp.SetBodyRaw(encoded)
+	appendHeader(&resp.Header, "Vary", "Accept-Encoding")
+}
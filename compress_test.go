@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompressResponse(t *testing.T) {
+	body := strings.Repeat("hello ", 100)
+	tests := []struct {
+		name       string
+		status     int
+		header     string
+		compressed bool
+	}{
+		{"full", fasthttp.StatusOK, "", true},
+		{"partial", fasthttp.StatusPartialContent, "Content-Range", false},
+		{"partial without Content-Range", fasthttp.StatusPartialContent, "", false},
+		{"Content-Range on 200", fasthttp.StatusOK, "Content-Range", false},
+	}
+	for _, tt := range tests {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.Set("Accept-Encoding", "gzip")
+		ctx.Response.SetStatusCode(tt.status)
+		ctx.Response.Header.SetContentType("text/plain")
+		if tt.header != "" {
+			ctx.Response.Header.Set(tt.header, "bytes 0-599/1200")
+		}
+		ctx.Response.SetBodyString(body)
+		compressResponse(&ctx)
+		if got := string(ctx.Response.Header.ContentEncoding()) == "gzip"; got != tt.compressed {
+			t.Errorf("%s: compressed %v, want %v", tt.name, got, tt.compressed)
+		}
+		if !tt.compressed && string(ctx.Response.Body()) != body {
+			t.Errorf("%s: body changed", tt.name)
+		}
+	}
+}
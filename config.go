@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var configFile = flag.String(`config`, ``, `Config file (yaml or json) keyed by flag names. Command line flags override it. Eg: config.yaml`)
+
+// loadConfig reads a config file into flag values, a scalar is a one item list
+func loadConfig(name string) (map[string][]string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if filepath.Ext(name) == ".json" || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseJSONConfig(data)
+	}
+	return parseYAMLConfig(data)
+}
+
+func parseJSONConfig(data []byte) (map[string][]string, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string, len(raw))
+	for k, v := range raw {
+		s, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", k, err)
+		}
+		values[k] = s
+	}
+	return values, nil
+}
+
+func configValue(v any) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []any:
+		items := []string{}
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, s...)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// parseYAMLConfig parses the flat yaml subset a config needs:
+// "key: value" scalars, "[a, b]" and "- item" lists, quotes and # comments
+func parseYAMLConfig(data []byte) (map[string][]string, error) {
+	values := map[string][]string{}
+	var listKey string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without key", n)
+			}
+			item, err := yamlScalar(strings.TrimPrefix(trimmed, "-"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			values[listKey] = append(values[listKey], item)
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", n)
+		}
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", n)
+		}
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		listKey = ""
+		switch {
+		case v == "" || v[0] == '#':
+			listKey = k
+			values[k] = []string{}
+		case v[0] == '[':
+			end := strings.LastIndexByte(v, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated list", n)
+			}
+			items := []string{}
+			for _, item := range strings.Split(v[1:end], ",") {
+				if strings.TrimSpace(item) == "" {
+					continue
+				}
+				item, err := yamlScalar(item)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				items = append(items, item)
+			}
+			values[k] = items
+		default:
+			v, err := yamlScalar(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			values[k] = []string{v}
+		}
+	}
+	return values, s.Err()
+}
+
+// yamlScalar unquotes a value or strips its trailing comment
+func yamlScalar(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := strings.LastIndexByte(v, '"')
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, `'`):
+		end := strings.LastIndexByte(v, '\'')
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return strings.ReplaceAll(v[1:end], `''`, `'`), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
+
+// commandLineFlags are the flags given on the command line, which config values don't override
+var commandLineFlags = map[string]bool{}
+
+// repeatedFlag is a flag adding a value per Set, eg: -header-rule
+type repeatedFlag interface {
+	flag.Value
+	repeated()
+}
+
+// setConfigFlag sets flag name from its config items: each item for a repeated flag,
+// else the items joined with commas
+func setConfigFlag(name string, items []string) error {
+	if _, ok := flag.Lookup(name).Value.(repeatedFlag); ok {
+		for _, item := range items {
+			if err := flag.Set(name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return flag.Set(name, strings.Join(items, ","))
+}
+
+// applyConfig sets flags from config values, unless given on the command line
+func applyConfig(values map[string][]string) error {
+	flag.Visit(func(f *flag.Flag) {
+		commandLineFlags[f.Name] = true
+	})
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if flag.Lookup(k) == nil {
+			return fmt.Errorf("unknown config key: %s", k)
+		}
+		if commandLineFlags[k] {
+			continue
+		}
+		if err := setConfigFlag(k, values[k]); err != nil {
+			return fmt.Errorf("config key %s: %v", k, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigHeaderRuleList(t *testing.T) {
+	want := headerRules{{op: "set", name: "A", value: "1"}, {op: "del", name: "B"}}
+	for name, config := range map[string]string{
+		"inline.yaml": "header-rule: [set:A=1, del:B]\n",
+		"items.yaml":  "header-rule:\n  - set:A=1\n  - del:B\n",
+		"list.json":   `{"header-rule": ["set:A=1", "del:B"]}`,
+		"one.yaml":    "header-rule: set:A=1,2\n",
+	} {
+		file := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		values, err := loadConfig(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// applyConfig takes a key set once as given on the command line, so set it directly
+		headerRewrites = nil
+		if err = setConfigFlag("header-rule", values["header-rule"]); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		w := want
+		if name == "one.yaml" {
+			// a scalar is one rule, commas included
+			w = headerRules{{op: "set", name: "A", value: "1,2"}}
+		}
+		if !reflect.DeepEqual(headerRewrites, w) {
+			t.Errorf("%s: rules %v, want %v", name, headerRewrites, w)
+		}
+	}
+	headerRewrites = nil
+}
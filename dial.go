@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var dialRetries = flag.Int(`dial-retries`, 0, `Retry refused or timed out outbound dials this many times with exponential backoff, within -dial-timeout`)
+
+// first backoff between dial retries, doubled on every retry
+var dialRetryBackoff = 100 * time.Millisecond
+
+func retryableDialError(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryingDial retries dial on connection-level errors while the overall
+// dial timeout allows it, each attempt only gets what is left of it
+func retryingDial(dial func(network, address string) (net.Conn, error), retries int) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		deadline := time.Now().Add(*dialTimeout)
+		backoff := dialRetryBackoff
+		for attempt := 1; ; attempt++ {
+			c, err := dialBefore(dial, network, address, deadline)
+			if err == nil || attempt > retries || !retryableDialError(err) {
+				return c, err
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return nil, err
+			}
+			log.Println("Dial retry:", address, attempt, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// dialBefore gives up on dial at deadline, a conn it establishes later is closed
+func dialBefore(dial func(network, address string) (net.Conn, error), network, address string, deadline time.Time) (net.Conn, error) {
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		c, err := dial(network, address)
+		results <- result{c, err}
+	}()
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	select {
+	case res := <-results:
+		return res.c, res.err
+	case <-t.C:
+		go func() {
+			if res := <-results; res.c != nil {
+				res.c.Close()
+			}
+		}()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: os.ErrDeadlineExceeded}
+	}
+}
+
+// splitHostPortDefault splits host[:port], [ipv6][:port] or a bare ipv6 literal, zoned ones too,
+// using defaultPort when the port is missing or empty
+func splitHostPortDefault(addr, defaultPort string) (host, port string, err error) {
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return addr, defaultPort, nil
+	}
+	host, port, err = net.SplitHostPort(addr)
+	if err != nil {
+		var addrErr *net.AddrError
+		if !errors.As(err, &addrErr) || addrErr.Err != "missing port in address" {
+			return "", "", err
+		}
+		host = addr
+		if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+			host = addr[1 : len(addr)-1]
+		}
+		if strings.ContainsAny(host, "[]") {
+			return "", "", err
+		}
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	return host, port, nil
+}
+
+// validHostname accepts an IP literal, an ipv6 one with a zone named like a label, or a DNS name:
+// dot separated labels of up to 63 letters, digits, '-' or '_', 253 bytes at most, with an optional trailing dot
+func validHostname(host string) bool {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Zone() == "" || validLabel(ip.Zone())
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !validLabel(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 {
+		return false
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validPort accepts a decimal port 1-65535
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535 && port[0] != '+'
+}
+
+// dialErrorStatus maps an outbound error to the status a proxy answers with:
+// 503 over -max-tunnels, while shutting down or for an open circuit, -private-block-status for blocked addresses, 504 for timeouts and 502 otherwise
+func dialErrorStatus(err error) int {
+	if errors.Is(err, errTooManyTunnels) || errors.Is(err, errShuttingDown) || errors.Is(err, errCircuitOpen) {
+		return fasthttp.StatusServiceUnavailable
+	}
+	var blocked *blockedAddrError
+	if errors.As(err, &blocked) {
+		return *privateBlockStatus
+	}
+	var netErr net.Error
+	if errors.Is(err, fasthttp.ErrTimeout) || errors.Is(err, fasthttp.ErrDialTimeout) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return fasthttp.StatusGatewayTimeout
+	}
+	return fasthttp.StatusBadGateway
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSplitHostPortDefault(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"host", "host", "80"},
+		{"host:", "host", "80"},
+		{"host:8080", "host", "8080"},
+		{"1.2.3.4", "1.2.3.4", "80"},
+		{"1.2.3.4:8080", "1.2.3.4", "8080"},
+		{"[::1]", "::1", "80"},
+	}
+	for _, tt := range tests {
+		host, port, err := splitHostPortDefault(tt.addr, "80")
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("splitHostPortDefault(%q) = %q, %q, %v; want %q, %q", tt.addr, host, port, err, tt.host, tt.port)
+		}
+	}
+}
+
+func TestSplitHostPortDefaultInvalid(t *testing.T) {
+	for _, addr := range []string{"[::1", "::1]", "a:b:c", "[host]x"} {
+		if host, port, err := splitHostPortDefault(addr, "80"); err == nil {
+			t.Errorf("splitHostPortDefault(%q) = %q, %q; want an error", addr, host, port)
+		}
+	}
+}
+
+func TestRequestDefaultPort(t *testing.T) {
+	tests := []struct {
+		raw, host, port string
+	}{
+		{"GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", "80"},
+		{"GET http://example.com:8080/ HTTP/1.1\r\nHost: example.com:8080\r\n\r\n", "example.com", "8080"},
+		{"GET https://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", "443"},
+		{"GET https://example.com:8443/ HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "example.com", "8443"},
+		{"GET HTTPS://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", "443"},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", "80"},
+		{"CONNECT example.com HTTP/1.1\r\nHost: example.com\r\n\r\n", "example.com", "443"},
+		{"CONNECT example.com:8443 HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "example.com", "8443"},
+	}
+	for _, tt := range tests {
+		var req fasthttp.Request
+		if err := req.Read(bufio.NewReader(strings.NewReader(tt.raw))); err != nil {
+			t.Fatalf("%q: %v", tt.raw, err)
+		}
+		host, port, err := splitHostPortDefault(requestTarget(&req), requestDefaultPort(&req))
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("%q: %q, %q, %v; want %q, %q", tt.raw, host, port, err, tt.host, tt.port)
+		}
+	}
+}
+
+func TestSplitHostPortDefaultIPv6(t *testing.T) {
+	tests := []struct {
+		addr, host, port string
+	}{
+		{"::1", "::1", "443"},
+		{"[::1]", "::1", "443"},
+		{"[::1]:", "::1", "443"},
+		{"[::1]:8443", "::1", "8443"},
+		{"2001:db8::1", "2001:db8::1", "443"},
+		{"[2001:db8::1]:8443", "2001:db8::1", "8443"},
+		{"fe80::1%eth0", "fe80::1%eth0", "443"},
+		{"[fe80::1%eth0]", "fe80::1%eth0", "443"},
+		{"[fe80::1%eth0]:8443", "fe80::1%eth0", "8443"},
+	}
+	for _, tt := range tests {
+		host, port, err := splitHostPortDefault(tt.addr, "443")
+		if err != nil || host != tt.host || port != tt.port {
+			t.Errorf("splitHostPortDefault(%q) = %q, %q, %v; want %q, %q", tt.addr, host, port, err, tt.host, tt.port)
+		}
+		if !validHostname(host) {
+			t.Errorf("validHostname(%q) = false", host)
+		}
+	}
+}
+
+func TestValidHostnameZone(t *testing.T) {
+	for _, host := range []string{"fe80::1%eth 0", "fe80::1%eth0\r\n", "1.2.3.4%eth0"} {
+		if validHostname(host) {
+			t.Errorf("validHostname(%q) = true", host)
+		}
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"example.com.", true},
+		{"_srv.example-1.com", true},
+		{"1.2.3.4", true},
+		{"::1", true},
+		{long + ".com", true},
+		{long + "a.com", false},
+		{strings.Repeat(long+".", 4) + "com", false},
+		{"", false},
+		{".", false},
+		{"a..com", false},
+		{"exa mple.com", false},
+		{"example.com\r\nX-Injected: 1", false},
+		{"example.com\n", false},
+		{"exa\tmple.com", false},
+		{"example.com\x00", false},
+		{"ex%0d%0aample.com", false},
+		{"user@example.com", false},
+		{"example.com/path", false},
+	}
+	for _, tt := range tests {
+		if ok := validHostname(tt.host); ok != tt.ok {
+			t.Errorf("validHostname(%q) = %v, want %v", tt.host, ok, tt.ok)
+		}
+	}
+}
+
+func TestValidPort(t *testing.T) {
+	for port, ok := range map[string]bool{"1": true, "443": true, "65535": true, "0": false, "65536": false, "-1": false, "+80": false, "80 ": false, "": false, "http": false} {
+		if validPort(port) != ok {
+			t.Errorf("validPort(%q) = %v, want %v", port, !ok, ok)
+		}
+	}
+}
+
+func TestRetryingDialBudget(t *testing.T) {
+	old := *dialTimeout
+	*dialTimeout = time.Second
+	defer func() {
+		*dialTimeout = old
+	}()
+	// every attempt times out on its own after 800ms
+	slow := func(network, address string) (net.Conn, error) {
+		time.Sleep(800 * time.Millisecond)
+		return nil, &net.OpError{Op: "dial", Net: network, Err: os.ErrDeadlineExceeded}
+	}
+	start := time.Now()
+	_, err := retryingDial(slow, 5)("tcp", "example.com:80")
+	if d := time.Since(start); d > 1400*time.Millisecond {
+		t.Errorf("gave up after %v, over -dial-timeout", d)
+	}
+	if dialErrorStatus(err) != fasthttp.StatusGatewayTimeout {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var dohURL = flag.String(`doh`, ``, `Resolve destination hostnames with DNS-over-HTTPS. Eg: https://1.1.1.1/dns-query`)
+var dnsCacheTTL = flag.Duration(`dns-cache-ttl`, 0, `Cache resolved destination hostnames, capping record TTLs to this (0: no cache for the OS resolver, uncapped for -doh). Eg: 5m`)
+var dialStagger = flag.Duration(`dial-stagger`, 250*time.Millisecond, `Happy Eyeballs delay between connection attempts to the addresses of a host`)
+var dnsCacheSize = flag.Int(`dns-cache-size`, 4096, `Max number of cached hostnames`)
+
+// how long NXDOMAIN results are cached
+var dnsNegativeTTL = 10 * time.Second
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+)
+
+var errDNSMalformed = errors.New("dns: malformed message")
+
+// errNoAddresses is returned by dialHappyEyeballs given no IP to dial
+var errNoAddresses = errors.New("dial: no addresses")
+
+// buildDNSQuery builds a RFC 1035 query for host, id 0 as RFC 8484 recommends
+func buildDNSQuery(host string, qtype uint16) ([]byte, error) {
+	b := []byte{0, 0, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0} // RD, QDCOUNT=1
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("dns: invalid name %q", host)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	b = binary.BigEndian.AppendUint16(b, dnsClassIN)
+	return b, nil
+}
+
+// skipDNSName returns the offset after the (possibly compressed) name at off
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSMalformed
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += l + 1
+		}
+	}
+}
+
+// parseDNSAnswers returns the qtype addresses of a response and their min TTL
+func parseDNSAnswers(msg []byte, host string, qtype uint16) ([]net.IP, uint32, error) {
+	if len(msg) < 12 {
+		return nil, 0, errDNSMalformed
+	}
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0:
+	case 3:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: fmt.Sprintf("server failure, rcode %d", rcode), Name: host}
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4
+	}
+
+	var ips []net.IP
+	var minTTL uint32
+	for i := 0; i < ancount; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		if off+10 > len(msg) {
+			return nil, 0, errDNSMalformed
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, 0, errDNSMalformed
+		}
+		if typ == qtype && (rdlen == net.IPv4len || rdlen == net.IPv6len) {
+			ips = append(ips, net.IP(append([]byte(nil), msg[off:off+rdlen]...)))
+			if minTTL == 0 || ttl < minTTL {
+				minTTL = ttl
+			}
+		}
+		off += rdlen
+	}
+	return ips, minTTL, nil
+}
+
+// dohResolver resolves hostnames over DNS-over-HTTPS (RFC 8484)
+type dohResolver struct {
+	url    string
+	client *fasthttp.Client
+}
+
+func newDoHResolver(url string) *dohResolver {
+	return &dohResolver{
+		url: url,
+		client: &fasthttp.Client{
+			ReadTimeout:         5 * time.Second,
+			MaxIdleConnDuration: time.Minute,
+		},
+	}
+}
+
+func (r *dohResolver) query(host string, qtype uint16) ([]net.IP, uint32, error) {
+	q, err := buildDNSQuery(host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(r.url)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.SetContentType("application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	req.SetBody(q)
+	if err = r.client.DoTimeout(req, resp, *dialTimeout); err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, 0, fmt.Errorf("doh: %s status %d", r.url, resp.StatusCode())
+	}
+	return parseDNSAnswers(resp.Body(), host, qtype)
+}
+
+// LookupIP returns IPv4 addresses followed by IPv6 addresses of host and their TTL
+func (r *dohResolver) LookupIP(host string) ([]net.IP, time.Duration, error) {
+	type result struct {
+		ips []net.IP
+		ttl uint32
+		err error
+	}
+	var a, aaaa result
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.ips, a.ttl, a.err = r.query(host, dnsTypeA)
+	}()
+	go func() {
+		defer wg.Done()
+		aaaa.ips, aaaa.ttl, aaaa.err = r.query(host, dnsTypeAAAA)
+	}()
+	wg.Wait()
+
+	ips := append(a.ips, aaaa.ips...)
+	if len(ips) == 0 {
+		if a.err != nil {
+			return nil, 0, a.err
+		}
+		if aaaa.err != nil {
+			return nil, 0, aaaa.err
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	ttl := a.ttl
+	if ttl == 0 || (aaaa.ttl != 0 && aaaa.ttl < ttl) {
+		ttl = aaaa.ttl
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+func lookupSystemIP(host string) ([]net.IP, error) {
+	ips, _, err := lookupSystem(host)
+	return ips, err
+}
+
+// lookupSystem resolves host with the OS resolver, which reports no TTL
+func lookupSystem(host string) ([]net.IP, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *dialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, 0, nil
+}
+
+type dnsCacheEntry struct {
+	host    string
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// dnsCache is a LRU cache of lookup results, positive results are kept for
+// their TTL capped by maxTTL (0: uncapped), NXDOMAIN for dnsNegativeTTL
+type dnsCache struct {
+	lookup func(host string) ([]net.IP, time.Duration, error)
+	maxTTL time.Duration
+	size   int
+
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newDNSCache(lookup func(host string) ([]net.IP, time.Duration, error), maxTTL time.Duration, size int) *dnsCache {
+	return &dnsCache{
+		lookup: lookup,
+		maxTTL: maxTTL,
+		size:   size,
+		ll:     list.New(),
+		items:  make(map[string]*list.Element),
+	}
+}
+
+func (c *dnsCache) get(host string) (*dnsCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[host]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*dnsCacheEntry)
+	if time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, host)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e, true
+}
+
+func (c *dnsCache) put(e *dnsCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[e.host]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[e.host] = c.ll.PushFront(e)
+	for c.size > 0 && c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*dnsCacheEntry).host)
+	}
+}
+
+func (c *dnsCache) LookupIP(host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if e, ok := c.get(host); ok {
+		return e.ips, e.err
+	}
+
+	ips, ttl, err := c.lookup(host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			ttl = dnsNegativeTTL
+			if c.maxTTL > 0 && c.maxTTL < ttl {
+				ttl = c.maxTTL
+			}
+			c.put(&dnsCacheEntry{host: host, err: err, expires: time.Now().Add(ttl)})
+		}
+		return nil, err
+	}
+	if ttl == 0 || (c.maxTTL > 0 && c.maxTTL < ttl) {
+		ttl = c.maxTTL
+	}
+	if ttl > 0 {
+		c.put(&dnsCacheEntry{host: host, ips: ips, expires: time.Now().Add(ttl)})
+	}
+	return ips, nil
+}
+
+// resolvingDial resolves hostnames with lookup and dials the resulting IPs
+// with Happy Eyeballs. Without DualStack only the first address family is tried.
+func resolvingDial(lookup func(host string) ([]net.IP, error)) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if _, err := publicIPs(host, []net.IP{ip}); err != nil {
+				return nil, err
+			}
+			d, err := dialerFor(ip)
+			if err != nil {
+				return nil, err
+			}
+			return d.Dial(network, address)
+		}
+		ips, err := lookup(host)
+		if err != nil {
+			return nil, err
+		}
+		// dial only the addresses checked here, so a second lookup can't rebind to internal ones
+		if ips, err = publicIPs(host, ips); err != nil {
+			return nil, err
+		}
+		return dialHappyEyeballs(network, sortHappyEyeballs(ips, netDialer.DualStack), port)
+	}
+}
+
+// sortHappyEyeballs interleaves address families starting with IPv6 (RFC 8305)
+func sortHappyEyeballs(ips []net.IP, dualStack bool) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	if len(v6) == 0 {
+		return v4
+	}
+	if !dualStack || len(v4) == 0 {
+		return v6
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			sorted = append(sorted, v6[i])
+		}
+		if i < len(v4) {
+			sorted = append(sorted, v4[i])
+		}
+	}
+	return sorted
+}
+
+// dialHappyEyeballs starts a dial to each ip every dialStagger, or as soon as
+// the previous one fails, and returns the first connection established.
+// Losing dials are cancelled and closed.
+func dialHappyEyeballs(network string, ips []net.IP, port string) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, errNoAddresses
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *dialTimeout)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(ips))
+	var next <-chan time.Time
+	started, pending := 0, 0
+	var firstErr error
+	for {
+		if started < len(ips) && next == nil {
+			ip := ips[started]
+			go func() {
+				d, err := dialerFor(ip)
+				if err != nil {
+					results <- result{nil, err}
+					return
+				}
+				c, err := d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				results <- result{c, err}
+			}()
+			started++
+			pending++
+			if started < len(ips) {
+				next = time.After(*dialStagger)
+			}
+		}
+
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if res := <-results; res.c != nil {
+							res.c.Close()
+						}
+					}
+				}(pending)
+				return res.c, nil
+			}
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			if pending == 0 && started == len(ips) {
+				return nil, firstErr
+			}
+			// start the next attempt right away
+			next = nil
+		case <-next:
+			next = nil
+		}
+	}
+}
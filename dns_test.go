@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialHappyEyeballsNoAddresses(t *testing.T) {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := dialHappyEyeballs("tcp", []net.IP{}, "80")
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err != errNoAddresses {
+			t.Errorf("got %v, want %v", err, errNoAddresses)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialHappyEyeballs blocked on no addresses")
+	}
+}
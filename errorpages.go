@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errorPagesDir = flag.String(`error-pages-dir`, ``, `Directory of bodies for the proxy's own error responses, named by status. Eg: 403.html, 502.json (default: a short text message)`)
+
+type errorPageBody struct {
+	contentType string
+	body        []byte
+}
+
+// errorPages holds the -error-pages-dir bodies by status
+var errorPages map[int]errorPageBody
+
+func loadErrorPages(dir string) (map[int]errorPageBody, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[int]errorPageBody)
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		status, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ext))
+		if err != nil || status < 400 || status > 599 || f.IsDir() {
+			continue
+		}
+		body, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ct := mime.TypeByExtension(ext)
+		if ct == "" {
+			ct = "text/plain; charset=utf-8"
+		}
+		pages[status] = errorPageBody{contentType: ct, body: body}
+	}
+	return pages, nil
+}
+
+// errorPage returns the body of an error response the proxy makes itself
+func errorPage(status int) (string, []byte) {
+	if p, ok := errorPages[status]; ok {
+		return p.contentType, p.body
+	}
+	return "text/plain; charset=utf-8", []byte(strconv.Itoa(status) + " " + fasthttp.StatusMessage(status) + "\n")
+}
+
+// setErrorPage fills in the body of an error response with none
+func setErrorPage(resp *fasthttp.Response) {
+	if status := resp.StatusCode(); status >= 400 && len(resp.Body()) == 0 {
+		ct, body := errorPage(status)
+		resp.Header.SetContentType(ct)
+		resp.SetBody(body)
+	}
+}
@@ -2,10 +2,18 @@ module http-proxy-server
 
 go 1.20
 
-require github.com/valyala/fasthttp v1.50.0
+require (
+	github.com/valyala/fasthttp v1.50.0
+	golang.org/x/crypto v0.7.0
+	shared v0.0.0
+)
 
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/klauspost/compress v1.16.3 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
 )
+
+replace shared => ./shared
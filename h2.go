@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var enableH2 = flag.Bool(`h2`, false, `Offer HTTP/2 with ALPN on TLS listeners for plain HTTP forwarding, CONNECT still needs HTTP/1.1`)
+
+var h2Servers struct {
+	sync.Mutex
+	s []*http.Server
+}
+
+// connListener hands out conns accepted elsewhere
+type connListener struct {
+	inner  net.Listener
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(inner net.Listener) *connListener {
+	return &connListener{inner: inner, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return l.inner.Close()
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.inner.Addr()
+}
+
+func (l *connListener) push(c net.Conn) {
+	select {
+	case l.conns <- c:
+	case <-l.closed:
+		c.Close()
+	}
+}
+
+// serveALPN serves TLS conns on ln that negotiated h2 with net/http, and the rest with srv
+func serveALPN(ln net.Listener, tlsConfig *tls.Config, srv *fasthttp.Server) error {
+	config := tlsConfig.Clone()
+	config.NextProtos = []string{"h2", "http/1.1"}
+	tln := tls.NewListener(ln, config)
+	h1, h2 := newConnListener(tln), newConnListener(tln)
+
+	h2srv := &http.Server{
+		Handler:           http.HandlerFunc(h2Handler),
+		ReadHeaderTimeout: serverReadTimeout(),
+		MaxHeaderBytes:    int(maxHeaderSize),
+		IdleTimeout:       *idleTimeout,
+		ConnState:         h2ConnState,
+	}
+	h2Servers.Lock()
+	h2Servers.s = append(h2Servers.s, h2srv)
+	h2Servers.Unlock()
+	go func() {
+		if err := h2srv.Serve(h2); err != nil && !errors.Is(err, net.ErrClosed) && err != http.ErrServerClosed {
+			log.Println("h2:", err)
+		}
+	}()
+
+	go func() {
+		defer h1.Close()
+		defer h2.Close()
+		for {
+			c, err := tln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					log.Println("h2 accept:", err)
+				}
+				return
+			}
+			go func() {
+				tc := c.(*tls.Conn)
+				if *readTimeout > 0 {
+					tc.SetDeadline(time.Now().Add(*readTimeout))
+				}
+				if err := tc.Handshake(); err != nil {
+					tc.Close()
+					return
+				}
+				if *readTimeout > 0 {
+					tc.SetDeadline(zeroTime)
+				}
+				if tc.ConnectionState().NegotiatedProtocol == "h2" {
+					h2.push(tc)
+				} else {
+					// see firstRequestListener
+					if d := serverReadTimeout(); d > 0 {
+						tc.SetReadDeadline(time.Now().Add(d))
+					}
+					h1.push(tc)
+				}
+			}()
+		}
+	}()
+	return srv.Serve(h1)
+}
+
+func shutdownH2(ctx context.Context) {
+	h2Servers.Lock()
+	defer h2Servers.Unlock()
+	for _, s := range h2Servers.s {
+		s.Shutdown(ctx)
+	}
+}
+
+// response headers net/http must not forward over h2
+var h2SkipHeaders = map[string]bool{
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Content-Length":    true,
+}
+
+// h2Conn stands in for the conn of an h2 stream handed to requestHandler,
+// with the addresses and TLS state of the conn the stream came on
+type h2Conn struct {
+	local, remote net.Addr
+	state         tls.ConnectionState
+}
+
+func (c *h2Conn) Read([]byte) (int, error)         { return 0, net.ErrClosed }
+func (c *h2Conn) Write([]byte) (int, error)        { return 0, net.ErrClosed }
+func (c *h2Conn) Close() error                     { return nil }
+func (c *h2Conn) LocalAddr() net.Addr              { return c.local }
+func (c *h2Conn) RemoteAddr() net.Addr             { return c.remote }
+func (c *h2Conn) SetDeadline(time.Time) error      { return nil }
+func (c *h2Conn) SetReadDeadline(time.Time) error  { return nil }
+func (c *h2Conn) SetWriteDeadline(time.Time) error { return nil }
+func (c *h2Conn) Handshake() error                 { return nil }
+
+func (c *h2Conn) ConnectionState() tls.ConnectionState {
+	return c.state
+}
+
+func newH2Conn(r *http.Request) *h2Conn {
+	c := &h2Conn{}
+	if r.TLS != nil {
+		c.state = *r.TLS
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.local = addr
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		c.remote = addr
+	}
+	return c
+}
+
+// h2Handler serves plain HTTP requests received over h2 with requestHandler, so they are
+// forwarded as over HTTP/1.1. CONNECT and upgrades would need the stream as a conn.
+func h2Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		al := &accessLog{start: time.Now(), ID: newRequestID(), Method: r.Method, Remote: r.RemoteAddr, Host: r.Host}
+		metricRequests.Add(1)
+		al.Status = http.StatusMethodNotAllowed
+		h2Error(w, al.Status)
+		al.logln("Reject: CONNECT over h2", r.Host)
+		al.write()
+		return
+	}
+	// fasthttp replies to a body over the limit before its handler runs too
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBodySize)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h2Error(w, http.StatusRequestEntityTooLarge)
+		} else {
+			h2Error(w, http.StatusBadRequest)
+		}
+		return
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init2(newH2Conn(r), log.Default(), false)
+	ctx.Request.Header.SetMethod(r.Method)
+	ctx.Request.SetRequestURI("http://" + r.Host + r.URL.RequestURI())
+	ctx.Request.Header.SetHost(r.Host)
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			ctx.Request.Header.Add(k, v)
+		}
+	}
+	ctx.Request.SetBody(body)
+	requestHandler(ctx)
+
+	resp := &ctx.Response
+	resp.Header.VisitAll(func(k, v []byte) {
+		if key := string(k); !h2SkipHeaders[key] {
+			w.Header().Add(key, string(v))
+		}
+	})
+	w.WriteHeader(resp.StatusCode())
+	if resp.IsBodyStream() {
+		// a streamed body is logged once closed
+		flushBody(w, resp.BodyStream())
+		resp.CloseBodyStream()
+	} else {
+		w.Write(resp.Body())
+	}
+}
+
+// h2Error replies with the error page of status
+func h2Error(w http.ResponseWriter, status int) {
+	ct, body := errorPage(status)
+	w.Header().Set("Content-Type", ct)
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// h2 requests take the path of HTTP/1.1 ones: the target header and the rate limit apply
+func TestH2Handler(t *testing.T) {
+	dial, _, dialed := testOrigin(t)
+	testProxy(t, dial)
+	oldEnv, oldTarget := envCreds, *allowTargetHeader
+	defer func() {
+		envCreds, *allowTargetHeader = oldEnv, oldTarget
+		applyLive()
+	}()
+	envCreds, *allowTargetHeader = "user:secret", true
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+	// a byte per read, a second between the two of "ok"
+	globalLimiter.Store(newRateLimiter(1))
+	defer globalLimiter.Store(nil)
+
+	r := httptest.NewRequest(http.MethodGet, "http://front.example.com/", nil)
+	r.TLS = &tls.ConnectionState{NegotiatedProtocol: "h2"}
+	r.Header.Set("Proxy-Authorization", basicAuth("user:secret"))
+	r.Header.Set("X-Target-Host", "real.example.com")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h2Handler(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Fatalf("status %d, body %q", w.Code, w.Body.String())
+	}
+	if got := next(t, dialed); got != "real.example.com:80" {
+		t.Errorf("dialed %s, want the target header", got)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("body sent in %v, not rate limited", d)
+	}
+}
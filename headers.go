@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var serverName = flag.String(`server-name`, ``, `Identify the proxy with this name in a Via header on HTTP responses and Proxy-Agent on CONNECT responses (default: none)`)
+var forwardedFor = flag.Bool(`forwarded-for`, false, `Add X-Forwarded-For and Via headers to forwarded HTTP requests`)
+
+var headerRewrites headerRules
+
+func init() {
+	flag.Var(&headerRewrites, `header-rule`, `Rewrite a header of forwarded HTTP requests, repeatable and applied in order. Eg: set:User-Agent=Foo; del:Referer; add:X-Tag=1`)
+}
+
+const viaValue = "1.1 http-proxy-server"
+
+// hop-by-hop headers, RFC 7230 section 6.1
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// header is implemented by fasthttp's RequestHeader and ResponseHeader
+type header interface {
+	PeekAll(key string) [][]byte
+	Set(key, value string)
+	SetBytesV(key string, value []byte)
+}
+
+// appendHeader sets key to all its current values joined with value
+func appendHeader(h header, key, value string) {
+	values := h.PeekAll(key)
+	if len(values) == 0 {
+		h.Set(key, value)
+		return
+	}
+	joined := bytes.Join(values, []byte(", "))
+	joined = append(joined, ", "...)
+	joined = append(joined, value...)
+	h.SetBytesV(key, joined)
+}
+
+func addForwardedHeaders(h *fasthttp.RequestHeader, clientIP string) {
+	appendHeader(h, "X-Forwarded-For", clientIP)
+	appendHeader(h, "Via", viaValue)
+}
+
+// stripHopHeaders removes hop-by-hop headers, including those listed in Connection
+func stripHopHeaders(h *fasthttp.RequestHeader) {
+	// Peek and PeekAll give just "close" once the request is marked to close,
+	// VisitAll also has the raw values
+	var listed []string
+	h.VisitAll(func(key, value []byte) {
+		if !bytes.EqualFold(key, []byte("Connection")) {
+			return
+		}
+		for _, name := range bytes.Split(value, []byte(",")) {
+			if name = bytes.TrimSpace(name); len(name) != 0 {
+				listed = append(listed, string(name))
+			}
+		}
+	})
+	for _, name := range listed {
+		h.Del(name)
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// addProxyIdentity names the proxy in its own responses when -server-name is set
+func addProxyIdentity(ctx *fasthttp.RequestCtx) {
+	if *serverName == "" {
+		return
+	}
+	if ctx.IsConnect() {
+		ctx.Response.Header.Set("Proxy-Agent", *serverName)
+		return
+	}
+	version := "1.1"
+	if _, ok := ctx.Conn().(*h2Conn); ok {
+		version = "2"
+	}
+	appendHeader(&ctx.Response.Header, "Via", version+" "+*serverName)
+}
+
+// headerRule sets, adds or deletes one request header
+type headerRule struct {
+	op, name, value string
+}
+
+type headerRules []headerRule
+
+func (r *headerRules) String() string {
+	if r == nil {
+		return ""
+	}
+	rules := make([]string, len(*r))
+	for i, rule := range *r {
+		rules[i] = rule.op + ":" + rule.name
+		if rule.op != "del" {
+			rules[i] += "=" + rule.value
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+func (r *headerRules) repeated() {}
+
+func (r *headerRules) Set(s string) error {
+	op, rest, _ := strings.Cut(s, ":")
+	name, value, hasValue := strings.Cut(rest, "=")
+	name = strings.TrimSpace(name)
+	switch {
+	case name == "":
+	case op == "del" && !hasValue:
+		*r = append(*r, headerRule{op: op, name: name})
+		return nil
+	case (op == "set" || op == "add") && hasValue:
+		*r = append(*r, headerRule{op: op, name: name, value: value})
+		return nil
+	}
+	return fmt.Errorf("invalid header rule: %q", s)
+}
+
+// rewriteHeaders applies -header-rule in order, header names match case-insensitively
+func rewriteHeaders(h *fasthttp.RequestHeader) {
+	for _, rule := range headerRewrites {
+		switch rule.op {
+		case "set":
+			h.Set(rule.name, rule.value)
+		case "add":
+			h.Add(rule.name, rule.value)
+		case "del":
+			h.Del(rule.name)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestStripHopHeaders(t *testing.T) {
+	tests := []string{
+		"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close, X-Foo\r\nX-Foo: 1\r\nX-Bar: 1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\nConnection: X-Foo\r\nX-Foo: 1\r\nX-Bar: 1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: keep-alive,X-Foo\r\nX-Foo: 1\r\nX-Bar: 1\r\n\r\n",
+		// HTTP/1.0 without keep-alive is marked to close
+		"GET / HTTP/1.0\r\nHost: example.com\r\nConnection: X-Foo\r\nX-Foo: 1\r\nX-Bar: 1\r\n\r\n",
+	}
+	for _, raw := range tests {
+		var h fasthttp.RequestHeader
+		if err := h.Read(bufio.NewReader(strings.NewReader(raw))); err != nil {
+			t.Fatal(err)
+		}
+		h.SetConnectionClose()
+		stripHopHeaders(&h)
+		if h.Peek("X-Foo") != nil || h.Peek("Connection") != nil {
+			t.Errorf("%q: hop-by-hop headers left:\n%s", raw, h.String())
+		}
+		if string(h.Peek("X-Bar")) != "1" {
+			t.Errorf("%q: X-Bar stripped:\n%s", raw, h.String())
+		}
+	}
+}
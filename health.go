@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var healthListen = flag.String(`health-listen`, ``, `Health check listen address, serving /__health (503 while shutting down). Eg: 127.0.0.1:9101`)
+
+var startTime = time.Now()
+
+type healthStatus struct {
+	Status        string  `json:"status"`
+	Uptime        float64 `json:"uptime"`
+	Connections   int32   `json:"connections"`
+	ActiveTunnels int64   `json:"active_tunnels"`
+}
+
+// openConns counts the open client conns of servers, nil ones are skipped
+func openConns(servers []*fasthttp.Server) int32 {
+	var n int32
+	for _, srv := range servers {
+		if srv != nil {
+			n += srv.GetOpenConnectionsCount()
+		}
+	}
+	// fasthttp's count can dip below zero around hijacks and shutdown
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// serveHealth answers health checks with the state of servers, on its own listener
+// so it is never proxied and keeps answering while they drain
+func serveHealth(addr string, servers []*fasthttp.Server) {
+	log.Println(`Health listening:`, addr)
+	log.Panicln(fasthttp.ListenAndServe(addr, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) != "/__health" {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			return
+		}
+		st := healthStatus{
+			Status:        "ok",
+			Uptime:        time.Since(startTime).Seconds(),
+			Connections:   openConns(servers),
+			ActiveTunnels: metricActiveTunnels.Load(),
+		}
+		if shuttingDown.Load() {
+			st.Status = "shutting down"
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		}
+		ctx.SetContentType("application/json")
+		json.NewEncoder(ctx).Encode(st)
+	}))
+}
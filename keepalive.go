@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"time"
+)
+
+var outKeepalive = flag.Bool(`out-keepalive`, true, `Enable TCP keep-alive on outbound connections, so tunnels to upstreams gone silently dead get closed`)
+var outKeepalivePeriod = flag.Duration(`out-keepalive-period`, 15*time.Second, `Idle time before the first TCP keep-alive probe of outbound connections, and between probes. The probe count is the OS default`)
+
+// outKeepaliveDuration is the net.Dialer KeepAlive of -out-keepalive and -out-keepalive-period
+func outKeepaliveDuration() time.Duration {
+	if !*outKeepalive || *outKeepalivePeriod <= 0 {
+		return -1
+	}
+	return *outKeepalivePeriod
+}
+
+// setKeepAlive applies -out-keepalive to c, for conns dialed by other dialers than netDialer
+func setKeepAlive(c net.Conn) {
+	for {
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = u.NetConn()
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if d := outKeepaliveDuration(); d < 0 {
+		tc.SetKeepAlive(false)
+	} else {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(d)
+	}
+}
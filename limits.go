@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+var maxTunnels = flag.Int64(`max-tunnels`, 0, `Max concurrent CONNECT, SOCKS5 and upgraded tunnels (0: unlimited)`)
+var maxConnsPerIP = flag.Int(`max-conns-per-ip`, 0, `Max concurrent connections per client IP, HTTP and SOCKS5 alike, a tunnel holding the connection it came in on (0: unlimited)`)
+var rps = flag.Float64(`rps`, 0, `Max new requests per second per client IP, CONNECT and SOCKS5 included (0: unlimited)`)
+var burst = flag.Int(`burst`, 0, `Requests a client IP may make at once under -rps (default: -rps rounded up)`)
+
+var ipConns = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// acquireIPConn reports whether ip may open another connection
+func acquireIPConn(ip string) bool {
+	if *maxConnsPerIP <= 0 {
+		return true
+	}
+	ipConns.Lock()
+	defer ipConns.Unlock()
+	if ipConns.m[ip] >= *maxConnsPerIP {
+		return false
+	}
+	ipConns.m[ip]++
+	return true
+}
+
+func releaseIPConn(ip string) {
+	if *maxConnsPerIP <= 0 {
+		return
+	}
+	ipConns.Lock()
+	defer ipConns.Unlock()
+	if ipConns.m[ip] <= 1 {
+		delete(ipConns.m, ip)
+		return
+	}
+	ipConns.m[ip]--
+}
+
+// tooManyConns answers plain HTTP conns over -max-conns-per-ip
+var tooManyConns = []byte("HTTP/1.1 429 Too Many Requests\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+
+// ipConnLimitListener holds a -max-conns-per-ip slot per accepted conn until it is closed.
+// Conns over the limit are closed right away, plain HTTP ones after a 429.
+type ipConnLimitListener struct {
+	net.Listener
+	reply []byte
+}
+
+func (l ipConnLimitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+		if acquireIPConn(ip) {
+			return &ipConn{Conn: c, ip: ip}, nil
+		}
+		log.Println("Reject: too many connections", ip)
+		go func() {
+			defer c.Close()
+			if l.reply != nil {
+				c.SetWriteDeadline(time.Now().Add(*writeTimeout))
+				c.Write(l.reply)
+			}
+		}()
+	}
+}
+
+// ipConn releases its -max-conns-per-ip slot once closed
+type ipConn struct {
+	net.Conn
+	ip   string
+	once sync.Once
+}
+
+func (c *ipConn) Close() error {
+	c.once.Do(func() {
+		releaseIPConn(c.ip)
+	})
+	return c.Conn.Close()
+}
+
+func (c *ipConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Close()
+}
+
+func (c *ipConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// tunnels counts the open tunnels, for -max-tunnels and for a shutdown to wait on
+var tunnels struct {
+	sync.Mutex
+	n int64
+	// drained is closed once the last tunnel is released, see stopTunnels
+	drained chan struct{}
+}
+
+// acquireTunnel returns an error when no other tunnel may be opened
+func acquireTunnel() error {
+	tunnels.Lock()
+	defer tunnels.Unlock()
+	if shuttingDown.Load() {
+		return errShuttingDown
+	}
+	if *maxTunnels > 0 && tunnels.n >= *maxTunnels {
+		return errTooManyTunnels
+	}
+	tunnels.n++
+	return nil
+}
+
+func releaseTunnel() {
+	tunnels.Lock()
+	defer tunnels.Unlock()
+	tunnels.n--
+	if tunnels.n == 0 && tunnels.drained != nil {
+		close(tunnels.drained)
+		tunnels.drained = nil
+	}
+}
+
+// ipBucket is the request token bucket of one client IP
+type ipBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var ipRequests = struct {
+	sync.Mutex
+	m map[string]*ipBucket
+}{m: make(map[string]*ipBucket)}
+
+func burstSize() float64 {
+	if *burst > 0 {
+		return float64(*burst)
+	}
+	return math.Max(math.Ceil(*rps), 1)
+}
+
+// allowRequest reports whether ip may make another request under -rps
+func allowRequest(ip string) bool {
+	if *rps <= 0 {
+		return true
+	}
+	size := burstSize()
+	now := time.Now()
+	ipRequests.Lock()
+	defer ipRequests.Unlock()
+	b := ipRequests.m[ip]
+	if b == nil {
+		b = &ipBucket{tokens: size, last: now}
+		ipRequests.m[ip] = b
+	}
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()**rps, size)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evictIdleIPs periodically drops buckets that have refilled, which behave like new ones
+func evictIdleIPs(interval time.Duration) {
+	for range time.Tick(interval) {
+		size := burstSize()
+		now := time.Now()
+		ipRequests.Lock()
+		for ip, b := range ipRequests.m {
+			if b.tokens+now.Sub(b.last).Seconds()**rps >= size {
+				delete(ipRequests.m, ip)
+			}
+		}
+		ipRequests.Unlock()
+	}
+}
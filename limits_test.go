@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestMaxConnsPerIPCountsConns(t *testing.T) {
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+	dial, _, _ := testOrigin(t)
+	oldDial, oldMax := localDialFunc, *maxConnsPerIP
+	localDialFunc, *maxConnsPerIP = dial, 1
+	ln := fasthttputil.NewInmemoryListener()
+	srv := newServer(true)
+	go srv.Serve(ipConnLimitListener{Listener: ln, reply: tooManyConns})
+	defer func() {
+		srv.Shutdown()
+		httpClientLocal.CloseIdleConnections()
+		localDialFunc, *maxConnsPerIP = oldDial, oldMax
+	}()
+
+	raw := "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	first, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(first)
+	// an idle keep-alive conn still holds its slot, requests on it need no other
+	for i := 0; i < 2; i++ {
+		if resp := exchange(t, first, br, raw); resp.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("request %d: status %d", i, resp.StatusCode())
+		}
+	}
+
+	second, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.SetDeadline(time.Now().Add(5 * time.Second))
+	resp := &fasthttp.Response{}
+	if err = resp.Read(bufio.NewReader(second)); err != nil || resp.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("second conn: status %d, %v", resp.StatusCode(), err)
+	}
+	second.Close()
+
+	first.Close()
+	// the slot is released once the server sees the close
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		ipConns.Lock()
+		n := len(ipConns.m)
+		ipConns.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slot not released")
+		}
+	}
+	third, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if resp := exchange(t, third, bufio.NewReader(third), raw); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("third conn: status %d", resp.StatusCode())
+	}
+}
+
+func TestStopTunnelsDrains(t *testing.T) {
+	defer shuttingDown.Store(false)
+	if err := acquireTunnel(); err != nil {
+		t.Fatal(err)
+	}
+	done := stopTunnels()
+	if err := acquireTunnel(); err != errShuttingDown {
+		t.Fatalf("tunnel opened after shutdown: %v", err)
+	}
+	select {
+	case <-done:
+		t.Fatal("drained with a tunnel open")
+	default:
+	}
+	releaseTunnel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not drained once the tunnel closed")
+	}
+}
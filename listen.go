@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"shared"
+)
+
+type listener struct {
+	net.Listener
+	socks     bool
+	unix      bool
+	keepalive bool
+}
+
+// listenOne listens on addr, which may end in ?options. Eg: :8080?keepalive=false
+func listenOne(addr string) (*listener, error) {
+	l := &listener{socks: *socksMode, keepalive: true}
+	addr, opts, _ := strings.Cut(addr, "?")
+	if opts != "" {
+		values, err := url.ParseQuery(opts)
+		if err != nil {
+			return nil, err
+		}
+		for k := range values {
+			switch k {
+			case "keepalive":
+				if l.keepalive, err = strconv.ParseBool(values.Get(k)); err != nil {
+					return nil, fmt.Errorf("listen %s: keepalive: %v", addr, err)
+				}
+			default:
+				return nil, fmt.Errorf("listen %s: unknown option: %s", addr, k)
+			}
+		}
+	}
+	if strings.HasPrefix(addr, `socks5://`) {
+		l.socks = true
+		addr = addr[9:]
+	}
+
+	var err error
+	if strings.HasPrefix(addr, `unix:`) {
+		unixFile := addr[5:]
+		l.Listener, err = shared.ListenUnix(unixFile, *checkOnly)
+		if err != nil {
+			return nil, err
+		}
+		l.unix = true
+		log.Println(`Listening:`, unixFile)
+	} else {
+		l.Listener, err = listenTCP(addr)
+		if err != nil {
+			return nil, err
+		}
+		log.Println(`Listening:`, l.Addr().String())
+	}
+	if *proxyProtocol {
+		l.Listener = newProxyProtoListener(l.Listener)
+	}
+	return l, nil
+}
+
+// listenAll opens a listener per comma separated address of spec, or takes the
+// sockets systemd passed instead. On error the listeners already opened are closed.
+func listenAll(spec string) ([]*listener, error) {
+	var lns []*listener
+	activated, err := shared.SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	for _, ln := range activated {
+		l := &listener{Listener: ln, socks: *socksMode, keepalive: true, unix: ln.Addr().Network() == "unix"}
+		log.Println(`Listening (systemd):`, ln.Addr().String())
+		if *proxyProtocol {
+			l.Listener = newProxyProtoListener(l.Listener)
+		}
+		lns = append(lns, l)
+	}
+	if len(lns) != 0 {
+		return lns, nil
+	}
+	for _, addr := range strings.Split(spec, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		ln, err := listenOne(addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return nil, errors.New("no listen address")
+	}
+	return lns, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+var logFile = flag.String(`log-file`, ``, `Append logs to this file instead of stderr`)
+var syslogEnabled = flag.Bool(`syslog`, false, `Send logs to the local syslog instead of stderr: accepts as info, auth failures as warning, dial failures as error`)
+var syslogAddr = flag.String(`syslog-addr`, ``, `Send logs to this remote syslog, implies -syslog. Eg: udp://10.0.0.1:514, tcp://logs.lan:601`)
+
+type severity int
+
+const (
+	sevInfo severity = iota
+	sevWarning
+	sevError
+)
+
+// severityWriter is a log sink telling severities apart, lines written to it are info
+type severityWriter interface {
+	io.Writer
+	writeAt(sev severity, msg string) error
+}
+
+// logSink is the syslog writer once -syslog is set up, nil logs through the log package
+var logSink severityWriter
+
+// setupLogging points the log package and the access log at -log-file or syslog, stderr otherwise
+func setupLogging() error {
+	var w io.Writer
+	switch {
+	case *syslogEnabled || *syslogAddr != "":
+		s, err := openSyslog(*syslogAddr)
+		if err != nil {
+			return err
+		}
+		// syslog stamps lines itself
+		log.SetFlags(0)
+		logSink, w = s, s
+	case *logFile != "":
+		f, err := os.OpenFile(*logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		w = f
+	default:
+		return nil
+	}
+	log.SetOutput(w)
+	jsonLogger.SetOutput(w)
+	return nil
+}
+
+// logAt logs v as log.Println does, at sev when logging to syslog
+func logAt(sev severity, v ...any) {
+	if logSink == nil {
+		log.Println(v...)
+		return
+	}
+	logSink.writeAt(sev, fmt.Sprintln(v...))
+}
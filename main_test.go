@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// testProxy serves requestHandler on an in-memory listener, dialing every destination with dial
+func testProxy(t *testing.T, dial func(network, address string) (net.Conn, error)) *fasthttputil.InmemoryListener {
+	t.Helper()
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+	oldDial := localDialFunc
+	localDialFunc = dial
+	ln := fasthttputil.NewInmemoryListener()
+	srv := newServer(true)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Shutdown()
+		httpClientLocal.CloseIdleConnections()
+		localDialFunc = oldDial
+	})
+	return ln
+}
+
+// testOrigin returns a dial func connecting to an in-memory origin that answers "ok",
+// the headers of the requests the origin receives and the addresses dialed
+func testOrigin(t *testing.T) (func(network, address string) (net.Conn, error), <-chan string, <-chan string) {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	headers := make(chan string, 16)
+	dialed := make(chan string, 16)
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		headers <- ctx.Request.Header.String()
+		ctx.WriteString("ok")
+	}}
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Shutdown()
+	})
+	return func(network, address string) (net.Conn, error) {
+		dialed <- address
+		return ln.Dial()
+	}, headers, dialed
+}
+
+// noDial fails the test on any outbound dial
+func noDial(t *testing.T) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		t.Errorf("unexpected dial: %s", address)
+		return nil, net.ErrClosed
+	}
+}
+
+// exchange writes raw to c and reads one response
+func exchange(t *testing.T, c net.Conn, br *bufio.Reader, raw string) *fasthttp.Response {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	resp := &fasthttp.Response{}
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("%q: %v", raw, err)
+	}
+	return resp
+}
+
+// next returns the next value of ch, failing the test if none comes
+func next(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing reached the origin")
+		return ""
+	}
+}
+
+func TestProxyConnectionKeepAlive(t *testing.T) {
+	dial, headers, _ := testOrigin(t)
+	ln := testProxy(t, dial)
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+
+	raw := "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Connection: keep-alive\r\n\r\n"
+	for i := 0; i < 2; i++ {
+		resp := exchange(t, c, br, raw)
+		if resp.StatusCode() != fasthttp.StatusOK || resp.ConnectionClose() {
+			t.Fatalf("request %d: status %d, connection close %v", i, resp.StatusCode(), resp.ConnectionClose())
+		}
+		if h := next(t, headers); strings.Contains(strings.ToLower(h), "proxy-connection") {
+			t.Errorf("Proxy-Connection forwarded:\n%s", h)
+		}
+	}
+}
+
+func TestProxyConnectionClose(t *testing.T) {
+	dial, headers, _ := testOrigin(t)
+	ln := testProxy(t, dial)
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	resp := exchange(t, c, bufio.NewReader(c), "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\nProxy-Connection: close\r\n\r\n")
+	if !resp.ConnectionClose() {
+		t.Error("Proxy-Connection: close not honored")
+	}
+	if h := next(t, headers); strings.Contains(strings.ToLower(h), "proxy-connection") {
+		t.Errorf("Proxy-Connection forwarded:\n%s", h)
+	}
+}
+
+func TestHTTP10AbsoluteURI(t *testing.T) {
+	tests := []struct {
+		raw, dialed, host string
+	}{
+		{"GET http://example.com/path HTTP/1.0\r\n\r\n", "example.com:80", "example.com"},
+		{"GET http://example.com:8080/path?q=1 HTTP/1.0\r\n\r\n", "example.com:8080", "example.com:8080"},
+		{"GET http://user:pw@example.com/path HTTP/1.0\r\n\r\n", "example.com:80", "example.com"},
+		// the request line wins over a Host header
+		{"GET http://example.com/path HTTP/1.0\r\nHost: other.example\r\n\r\n", "example.com:80", "example.com"},
+	}
+	for _, tt := range tests {
+		dial, headers, dialed := testOrigin(t)
+		ln := testProxy(t, dial)
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := exchange(t, c, bufio.NewReader(c), tt.raw)
+		c.Close()
+		if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "ok" {
+			t.Errorf("%q: status %d, body %q", tt.raw, resp.StatusCode(), resp.Body())
+			continue
+		}
+		if addr := next(t, dialed); addr != tt.dialed {
+			t.Errorf("%q: dialed %s, want %s", tt.raw, addr, tt.dialed)
+		}
+		if h := next(t, headers); !strings.Contains(h, "\r\nHost: "+tt.host+"\r\n") || strings.Contains(h, "user:pw") {
+			t.Errorf("%q: origin got\n%s", tt.raw, h)
+		}
+	}
+}
+
+func TestHTTP10WithoutHost(t *testing.T) {
+	ln := testProxy(t, noDial(t))
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := exchange(t, c, bufio.NewReader(c), "GET /path HTTP/1.0\r\n\r\n"); resp.StatusCode() != fasthttp.StatusBadRequest {
+		t.Errorf("status %d, want 400", resp.StatusCode())
+	}
+}
+
+func TestInvalidHostRejected(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	for _, raw := range []string{
+		"CONNECT ex%0d%0aample.com:443 HTTP/1.1\r\nHost: ex%0d%0aample.com:443\r\n\r\n",
+		"CONNECT exa\tmple.com:443 HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: exa mple.com\r\n\r\n",
+		"GET http://" + long + ".com/ HTTP/1.1\r\nHost: " + long + ".com\r\n\r\n",
+		"CONNECT example.com:99999 HTTP/1.1\r\n\r\n",
+	} {
+		ln := testProxy(t, noDial(t))
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp := exchange(t, c, bufio.NewReader(c), raw); resp.StatusCode() != fasthttp.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", raw, resp.StatusCode())
+		}
+		c.Close()
+	}
+}
+
+// echoDial returns a dial func connecting to an in-memory origin echoing what it reads
+func echoDial(t *testing.T) func(network, address string) (net.Conn, error) {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return func(network, address string) (net.Conn, error) {
+		return ln.Dial()
+	}
+}
+
+func TestConnectResponseBytes(t *testing.T) {
+	ln := testProxy(t, echoDial(t))
+	for _, raw := range []string{
+		"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+		"CONNECT example.com:443 HTTP/1.0\r\n\r\n",
+	} {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = c.Write([]byte(raw + "ping")); err != nil {
+			t.Fatal(err)
+		}
+		// the tunnel echoes ping right after the response
+		want := string(connectEstablished) + "ping"
+		got := make([]byte, len(want))
+		if _, err = io.ReadFull(c, got); err != nil {
+			t.Fatalf("%q: %v", raw, err)
+		}
+		if string(got) != want {
+			t.Errorf("%q: got %q, want %q", raw, got, want)
+		}
+		c.Close()
+	}
+}
+
+func TestUpgradeHeaderRules(t *testing.T) {
+	dial, headers, _ := testOrigin(t)
+	ln := testProxy(t, dial)
+	defer func() {
+		headerRewrites = nil
+	}()
+	headerRewrites = headerRules{{op: "set", name: "User-Agent", value: "rewritten"}}
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	raw := "GET http://example.com/ws HTTP/1.1\r\nHost: example.com\r\nUser-Agent: original\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"
+	if _, err = c.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if h := next(t, headers); !strings.Contains(h, "\r\nUser-Agent: rewritten\r\n") {
+		t.Errorf("origin got\n%s", h)
+	}
+}
+
+func TestConnectClientGoneBeforeResponse(t *testing.T) {
+	old := *connectResponse
+	*connectResponse = "legacy"
+	defer func() {
+		*connectResponse = old
+	}()
+	dialed := make(chan net.Conn, 1)
+	echo := echoDial(t)
+	ln := testProxy(t, func(network, address string) (net.Conn, error) {
+		c, err := echo(network, address)
+		if err == nil {
+			dialed <- c
+		}
+		return c, err
+	})
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	var r net.Conn
+	select {
+	case r = <-dialed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel not dialed")
+	}
+	// the upstream conn is closed once the response fails, not left to -drain-timeout
+	r.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = r.Read(make([]byte, 1)); err != io.EOF && !errors.Is(err, net.ErrClosed) {
+		t.Errorf("upstream conn not closed: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var metricsListen = flag.String(`metrics-listen`, ``, `Prometheus metrics listen address. Eg: 127.0.0.1:9100`)
+
+type metric struct {
+	name string
+	help string
+	kind string
+	v    *atomic.Int64
+}
+
+var metrics []*metric
+
+func registerMetric(name, help, kind string, v *atomic.Int64) *atomic.Int64 {
+	metrics = append(metrics, &metric{name: name, help: help, kind: kind, v: v})
+	return v
+}
+
+func newCounter(name, help string) *atomic.Int64 {
+	return registerMetric(name, help, "counter", new(atomic.Int64))
+}
+
+func newGauge(name, help string) *atomic.Int64 {
+	return registerMetric(name, help, "gauge", new(atomic.Int64))
+}
+
+var (
+	metricRequests        = newCounter("proxy_requests_total", "Total proxy requests")
+	metricConnectRequests = newCounter("proxy_connect_requests_total", "CONNECT requests")
+	metricHTTPRequests    = newCounter("proxy_http_requests_total", "Plain HTTP requests")
+	metricActiveTunnels   = newGauge("proxy_active_tunnels", "Active CONNECT tunnels")
+	metricOpenConns       = newGauge("proxy_client_conns", "Open HTTP client connections, tunnels excepted, as left to drain on shutdown")
+	metricAuthFailures    = newCounter("proxy_auth_failures_total", "Proxy authentication failures")
+	metricDialErrors      = newCounter("proxy_dial_errors_total", "Upstream dial errors")
+	metricHTTPBytesIn     = newCounter("proxy_http_received_bytes_total", "Plain HTTP request body bytes received from clients")
+	metricHTTPBytesOut    = newCounter("proxy_http_sent_bytes_total", "Plain HTTP response body bytes sent to clients")
+	metricCacheHits       = newCounter("proxy_cache_hits_total", "Plain HTTP requests answered from -cache-size")
+	_                     = registerMetric("proxy_tunnel_received_bytes_total", "CONNECT tunnel bytes received from clients", "counter", &tunnelBytesUp)
+	_                     = registerMetric("proxy_tunnel_sent_bytes_total", "CONNECT tunnel bytes sent to clients", "counter", &tunnelBytesDown)
+)
+
+func metricsHandler(ctx *fasthttp.RequestCtx) {
+	if string(ctx.Path()) != "/metrics" {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		return
+	}
+	ctx.SetContentType("text/plain; version=0.0.4")
+	for _, m := range metrics {
+		fmt.Fprintf(ctx, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.v.Load())
+	}
+}
+
+func serveMetrics(addr string) {
+	log.Println(`Metrics listening:`, addr)
+	log.Panicln(fasthttp.ListenAndServe(addr, metricsHandler))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var mitm = flag.Bool(`mitm`, false, `Intercept CONNECT tunnels: terminate the client's TLS with a certificate for the host signed by -mitm-ca, and forward its requests over a new TLS connection to the origin. Clients must trust the CA. For debugging only`)
+var mitmCA = flag.String(`mitm-ca`, ``, `CA certificate file signing the certificates of -mitm`)
+var mitmCAKey = flag.String(`mitm-ca-key`, ``, `Private key file of -mitm-ca`)
+var mitmLogHeaders = flag.Bool(`mitm-log-headers`, false, `Log the request and response headers of intercepted requests`)
+
+// generated certificates kept, the cache starts over once full
+const maxMITMCerts = 1000
+
+// mitmCerts signs and caches a leaf certificate per host, all sharing one key
+type mitmCerts struct {
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	key   *ecdsa.PrivateKey
+	sync.Mutex
+	cache map[string]*tls.Certificate
+}
+
+// mitmer is nil unless -mitm
+var mitmer *mitmCerts
+
+var mitmServer *fasthttp.Server
+
+func loadMITMCA(certFile, keyFile string) (*mitmCerts, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ca.IsCA {
+		return nil, errors.New(certFile + " is not a CA certificate")
+	}
+	caKey, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New(keyFile + ": unsupported key type")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &mitmCerts{ca: ca, caKey: caKey, key: key, cache: make(map[string]*tls.Certificate)}, nil
+}
+
+// certFor returns the certificate for name, signing it on first use
+func (m *mitmCerts) certFor(name string) (*tls.Certificate, error) {
+	m.Lock()
+	defer m.Unlock()
+	if c := m.cache[name]; c != nil {
+		return c, nil
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		// allow for clients with clocks behind
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(365 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if tmpl.NotAfter.After(m.ca.NotAfter) {
+		tmpl.NotAfter = m.ca.NotAfter
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.ca, &m.key.PublicKey, m.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.cache) >= maxMITMCerts {
+		m.cache = make(map[string]*tls.Certificate)
+	}
+	c := &tls.Certificate{Certificate: [][]byte{der, m.ca.Raw}, PrivateKey: m.key}
+	m.cache[name] = c
+	return c, nil
+}
+
+// mitmConn is the client side of an intercepted tunnel, served by mitmServer
+type mitmConn struct {
+	*tls.Conn
+	remoteAddr string
+	client     *fasthttp.Client
+	tunnel     *accessLog
+	requests   int
+}
+
+func newMITMServer() *fasthttp.Server {
+	return &fasthttp.Server{
+		ErrorHandler:          serverErrorHandler,
+		Handler:               mitmHandler,
+		NoDefaultServerHeader: true,
+		ReadTimeout:           serverReadTimeout(),
+		HeaderReceived:        headerReceived,
+		ConnState:             requestConnState,
+		WriteTimeout:          *writeTimeout,
+		IdleTimeout:           *idleTimeout,
+		MaxRequestBodySize:    int(maxBodySize),
+		ReadBufferSize:        int(maxHeaderSize),
+		ReduceMemoryUsage:     true,
+	}
+}
+
+// interceptTunnel serves the requests sent over the hijacked CONNECT tunnel clientConn
+// to remoteAddr, when they start with a TLS handshake. Anything else is spliced as is.
+func interceptTunnel(clientConn net.Conn, dial func(network, address string) (net.Conn, error), client *fasthttp.Client, remoteAddr string, al *accessLog) {
+	clientRaw := clientConn
+	if u, ok := clientConn.(interface{ UnsafeConn() net.Conn }); ok {
+		clientRaw = u.UnsafeConn()
+	}
+	br := bufio.NewReader(clientConn)
+	clientRaw.SetReadDeadline(time.Now().Add(*readTimeout))
+	first, err := br.Peek(1)
+	clientRaw.SetReadDeadline(zeroTime)
+	if err != nil {
+		clientRaw.Close()
+		al.Status = fasthttp.StatusOK
+		al.write()
+		return
+	}
+	bc := &bufferedConn{Conn: clientRaw, r: br}
+
+	// a TLS handshake record
+	if first[0] != 0x16 {
+		r, err := dial("tcp", remoteAddr)
+		if err != nil {
+			metricDialErrors.Add(1)
+			clientRaw.Close()
+			al.Status = dialErrorStatus(err)
+			if !blockedPrivate(err, al, remoteAddr) {
+				al.errorln("httpsHandler:", remoteAddr, err)
+			}
+			al.write()
+			return
+		}
+		al.event("tunnel open")
+		relay(bc, r, remoteAddr, al)
+		return
+	}
+
+	hostname, _, _ := net.SplitHostPort(remoteAddr)
+	tc := tls.Server(bc, &tls.Config{
+		// CONNECT does not carry h2
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return mitmer.certFor(hello.ServerName)
+			}
+			return mitmer.certFor(hostname)
+		},
+	})
+	metricActiveTunnels.Add(1)
+	defer metricActiveTunnels.Add(-1)
+	untrack := trackTunnel(&openTunnel{client: clientRaw, remote: clientRaw, dest: remoteAddr, al: al})
+	al.event("tunnel intercepted")
+
+	mc := &mitmConn{Conn: tc, remoteAddr: remoteAddr, client: client, tunnel: al}
+	if d := *maxTunnelDuration; d > 0 {
+		timer := time.AfterFunc(d, func() {
+			al.logln("Tunnel max duration reached:", remoteAddr)
+			clientRaw.Close()
+		})
+		defer timer.Stop()
+	}
+	if err := mitmServer.ServeConn(mc); err != nil && !errors.Is(err, net.ErrClosed) {
+		al.logln("Intercept:", remoteAddr, err)
+	}
+	untrack()
+	clientRaw.Close()
+
+	al.logln("Intercepted tunnel closed:", remoteAddr, "requests:", mc.requests)
+	al.Status = fasthttp.StatusOK
+	al.write()
+}
+
+// mitmHandler forwards a request read off an intercepted tunnel to the tunnel's origin over TLS
+func mitmHandler(ctx *fasthttp.RequestCtx) {
+	requestRead(ctx)
+	t := ctx.Conn().(*mitmConn)
+	t.requests++
+	al := &accessLog{
+		start:  time.Now(),
+		ID:     newRequestID(),
+		Method: string(ctx.Method()),
+		Remote: ctx.RemoteAddr().String(),
+		Host:   t.remoteAddr,
+		User:   t.tunnel.User,
+		Cert:   t.tunnel.Cert,
+	}
+	if tp := traceRequest(al, string(ctx.Request.Header.Peek("traceparent"))); tp != "" {
+		ctx.Request.Header.Set("traceparent", tp)
+	}
+	forwarded := false
+	// a streamed response is logged once its body is sent
+	streaming := false
+	defer func() {
+		if *requestIDHeader != "" {
+			ctx.Response.Header.Set(*requestIDHeader, al.ID)
+		}
+		if !forwarded {
+			setErrorPage(&ctx.Response)
+		}
+		al.Status = ctx.Response.StatusCode()
+		if !streaming {
+			al.write()
+		}
+	}()
+	metricRequests.Add(1)
+	metricHTTPRequests.Add(1)
+
+	if isUpgrade(&ctx.Request.Header) {
+		ctx.SetStatusCode(fasthttp.StatusNotImplemented)
+		al.logln("Reject: upgrade in intercepted tunnel", t.remoteAddr)
+		return
+	}
+	if *mitmLogHeaders {
+		al.logln("Intercepted request:", t.remoteAddr, "\n"+strings.TrimSpace(ctx.Request.Header.String()))
+	}
+	// copying the origin response resets the flag, see requestHandler
+	closeConn := ctx.Request.Header.ConnectionClose()
+	stripHopHeaders(&ctx.Request.Header)
+	if *forwardedFor {
+		addForwardedHeaders(&ctx.Request.Header, ctx.RemoteIP().String())
+	}
+	timeout := requestTimeout(&ctx.Request.Header)
+	rewriteHeaders(&ctx.Request.Header)
+	// dial the tunnel's destination, keep the Host header
+	ctx.Request.URI().SetScheme("https")
+	ctx.Request.URI().SetHost(t.remoteAddr)
+	ctx.Request.UseHostHeader = true
+
+	metricHTTPBytesIn.Add(int64(len(ctx.Request.Body())))
+	done := upstreamRequest(t.remoteAddr)
+	resp := fasthttp.AcquireResponse()
+	err := t.client.DoTimeout(&ctx.Request, resp, timeout)
+	if err == nil {
+		streaming, err = streamResponse(resp)
+	}
+	if err != nil {
+		done()
+		fasthttp.ReleaseResponse(resp)
+		failDial(ctx, al, "Intercept:", t.remoteAddr, err)
+		return
+	}
+	forwarded = true
+	if *mitmLogHeaders {
+		al.logln("Intercepted response:", t.remoteAddr, "\n"+strings.TrimSpace(resp.Header.String()))
+	}
+	al.Received = int64(len(ctx.Request.Body()))
+	t.tunnel.Received += al.Received
+	if streaming {
+		// the body is sent before the server reads the next request of the tunnel
+		streamBody(ctx, resp, al, timeout, func() {
+			done()
+			t.tunnel.Sent += al.Sent
+		})
+	} else {
+		done()
+		resp.CopyTo(&ctx.Response)
+		fasthttp.ReleaseResponse(resp)
+		al.Sent = int64(len(ctx.Response.Body()))
+		metricHTTPBytesOut.Add(al.Sent)
+		t.tunnel.Sent += al.Sent
+	}
+	if closeConn {
+		ctx.SetConnectionClose()
+	}
+}
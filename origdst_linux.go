@@ -0,0 +1,54 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv4.h and netfilter_ipv6/ip6_tables.h
+const soOriginalDst = 80
+
+// originalDst returns the address a connection redirected by netfilter was sent to
+func originalDst(c net.Conn) (string, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("original destination: not a tcp connection")
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	v6 := tc.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+	var addr string
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			// sockaddr_in6 fits in the first field of ip6_mtuinfo
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.IPPROTO_IPV6, soOriginalDst)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(port[0])<<8|int(port[1])))
+			return
+		}
+		// sockaddr_in fits in ipv6_mreq
+		mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		b := mreq.Multiaddr
+		addr = net.JoinHostPort(net.IPv4(b[4], b[5], b[6], b[7]).String(), strconv.Itoa(int(b[2])<<8|int(b[3])))
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, sockErr
+}
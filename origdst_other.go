@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func originalDst(c net.Conn) (string, error) {
+	return "", errors.New("original destination: transparent mode is only supported on Linux")
+}
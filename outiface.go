@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+)
+
+var outInterface = flag.String(`out-interface`, ``, `Send outbound connections from the address of this network interface matching the destination's family. Eg: eth1`)
+
+// dialers bound to the -out-interface addresses
+var outDialer4, outDialer6 *net.Dialer
+
+// setupOutInterface binds outDialer4 and outDialer6 to the first IPv4 and global IPv6 address of name
+func setupOutInterface(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("-out-interface: %v", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return fmt.Errorf("-out-interface %s: %v", name, err)
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		d := *netDialer
+		d.LocalAddr = &net.TCPAddr{IP: ipnet.IP}
+		switch {
+		case ipnet.IP.To4() != nil:
+			if outDialer4 == nil {
+				outDialer4 = &d
+				log.Println("Outbound IPv4:", name, ipnet.IP)
+			}
+		case !ipnet.IP.IsLinkLocalUnicast():
+			// link-local addresses would need a zone and only reach the local link
+			if outDialer6 == nil {
+				outDialer6 = &d
+				log.Println("Outbound IPv6:", name, ipnet.IP)
+			}
+		}
+	}
+	if outDialer4 == nil && outDialer6 == nil {
+		return fmt.Errorf("-out-interface %s: no usable address", name)
+	}
+	return nil
+}
+
+// dialerFor returns the dialer for a destination ip, by its family under -out-interface.
+// A nil ip, a hostname to be resolved by the dialer, prefers IPv4.
+func dialerFor(ip net.IP) (*net.Dialer, error) {
+	if *outInterface == "" {
+		return netDialer, nil
+	}
+	switch {
+	case ip == nil && outDialer4 != nil:
+		return outDialer4, nil
+	case ip == nil:
+		return outDialer6, nil
+	case ip.To4() != nil && outDialer4 != nil:
+		return outDialer4, nil
+	case ip.To4() == nil && outDialer6 != nil:
+		return outDialer6, nil
+	}
+	return nil, errors.New("-out-interface has no address of the family of " + ip.String())
+}
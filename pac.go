@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/valyala/fasthttp"
+	"shared"
+)
+
+var pacHost = flag.String(`pac-host`, ``, `Serve /proxy.pac pointing browsers at this advertised proxy address. Eg: proxy.example.com:8081`)
+
+// servePAC answers origin-form requests for /proxy.pac, addressed to the proxy itself
+func servePAC(ctx *fasthttp.RequestCtx) bool {
+	if *pacHost == "" {
+		return false
+	}
+	uri := ctx.Request.Header.RequestURI()
+	if len(uri) == 0 || uri[0] != '/' || string(ctx.Path()) != "/proxy.pac" {
+		return false
+	}
+
+	proxyType := "PROXY"
+	if *shared.ACMEDomains != "" || *certFile != "" && *keyFile != "" {
+		proxyType = "HTTPS"
+	}
+	ctx.SetContentType("application/x-ns-proxy-autoconfig")
+	fmt.Fprintf(ctx, "function FindProxyForURL(url, host) {\n\treturn %q;\n}\n", proxyType+" "+*pacHost)
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var unixAllowUIDs = flag.String(`unix-allow-uids`, ``, `Authenticate clients of unix socket listeners by the uid of the connecting process instead of Basic auth, comma separated uids. Eg: 0,1000 (linux only, default: Basic auth)`)
+
+// allowedUIDs is nil unless -unix-allow-uids
+var allowedUIDs map[uint32]bool
+
+func parseUIDs(spec string) (map[uint32]bool, error) {
+	uids := make(map[uint32]bool)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid: %q", s)
+		}
+		uids[uint32(uid)] = true
+	}
+	return uids, nil
+}
+
+// unixPeerAuth checks the peer uid of c against -unix-allow-uids.
+// isUnix is false for conns whose uid is unknown, such as TCP ones, which use Basic auth.
+func unixPeerAuth(c net.Conn) (user string, isUnix, allowed bool) {
+	if allowedUIDs == nil {
+		return "", false, false
+	}
+	uid, ok := peerUID(c)
+	if !ok {
+		return "", false, false
+	}
+	user = "uid:" + strconv.FormatUint(uint64(uid), 10)
+	return user, true, allowedUIDs[uid]
+}
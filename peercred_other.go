@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+const peerCredSupported = false
+
+func peerUID(c net.Conn) (uint32, bool) {
+	return 0, false
+}
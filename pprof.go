@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+var pprofListen = flag.String(`pprof-listen`, ``, `Serve net/http/pprof profiles on their own listener, a bare :port binds to localhost. Never public: profiles expose internals. Eg: :6060`)
+
+// servePprof serves /debug/pprof/ on addr, never through the proxy
+func servePprof(addr string) {
+	addr = adminAddr(addr)
+	host, _, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.Println("Warning: pprof listening on a non-loopback address:", addr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	log.Println(`Pprof listening:`, addr)
+	log.Panicln(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var proxyProtocol = flag.Bool(`proxy-protocol`, false, `Require a PROXY protocol v1 or v2 header on every inbound connection and use the client address it carries, for use behind HAProxy or a TCP load balancer`)
+
+// time allowed for a new connection to send its PROXY header
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoConn is a conn whose RemoteAddr is the client announced in its PROXY header
+type proxyProtoConn struct {
+	bufferedConn
+	remote net.Addr
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// proxyProtoListener reads PROXY headers off accepted conns without holding up Accept
+type proxyProtoListener struct {
+	net.Listener
+	conns chan net.Conn
+	errc  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newProxyProtoListener(ln net.Listener) *proxyProtoListener {
+	l := &proxyProtoListener{
+		Listener: ln,
+		conns:    make(chan net.Conn),
+		errc:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyProtoListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(time.Second)
+				continue
+			}
+			l.errc <- err
+			return
+		}
+		go func() {
+			pc, err := readProxyHeader(c)
+			if err != nil {
+				log.Println("Reject: proxy protocol", c.RemoteAddr(), err)
+				c.Close()
+				return
+			}
+			select {
+			case l.conns <- pc:
+			case <-l.done:
+				c.Close()
+			}
+		}()
+	}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errc:
+		l.errc <- err
+		return nil, err
+	}
+}
+
+func (l *proxyProtoListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// readProxyHeader consumes the PROXY v1 or v2 header at the start of c
+func readProxyHeader(c net.Conn) (net.Conn, error) {
+	c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReader(c)
+	pc := &proxyProtoConn{bufferedConn: bufferedConn{Conn: c, r: r}, remote: c.RemoteAddr()}
+	// both versions are told apart by their first 6 bytes
+	sig, err := r.Peek(6)
+	if err != nil {
+		return nil, err
+	}
+	var addr net.Addr
+	switch {
+	case string(sig) == "PROXY ":
+		addr, err = readProxyV1(r)
+	case bytes.Equal(sig, proxyV2Sig[:6]):
+		addr, err = readProxyV2(r)
+	default:
+		err = errors.New("missing header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if addr != nil {
+		pc.remote = addr
+	}
+	return pc, nil
+}
+
+// readProxyV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+// A nil addr means the conn's own address is kept.
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("v1 header too long")
+	}
+	f := strings.Split(s, " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header: %q", s)
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid v1 header: %q", s)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 parses the binary header, keeping the conn's own address for LOCAL and non-TCP
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hdr[:12], proxyV2Sig) {
+		return nil, errors.New("invalid v2 signature")
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version: %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0: // LOCAL, eg: load balancer health checks
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, fmt.Errorf("unsupported v2 command: %d", hdr[12]&0xf)
+	}
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errors.New("short v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errors.New("short v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
+
+var sendProxyProtocol = flag.Bool(`send-proxy-protocol`, false, `Send a PROXY protocol v2 header with the client address on outbound connections, before any other bytes`)
+
+// withProxyHeader wraps dial to announce src in a PROXY v2 header on every conn
+func withProxyHeader(dial func(network, address string) (net.Conn, error), src net.Addr) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		c, err := dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return writeProxyHeader(c, src)
+	}
+}
+
+// writeProxyHeader announces src on c just dialed, closing it on failure
+func writeProxyHeader(c net.Conn, src net.Addr) (net.Conn, error) {
+	if _, err := c.Write(proxyHeaderV2(src, c.RemoteAddr())); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+type proxyHeaderKey struct {
+	base *fasthttp.Client
+	src  string
+}
+
+type proxyHeaderClient struct {
+	client *fasthttp.Client
+	used   time.Time
+}
+
+// proxyHeaderClients are the clients of withProxyHeaderClient, one per client address and route,
+// so requests of a client conn reuse their upstream conns
+var proxyHeaderClients = struct {
+	sync.Mutex
+	m map[proxyHeaderKey]*proxyHeaderClient
+}{m: make(map[proxyHeaderKey]*proxyHeaderClient)}
+
+// withProxyHeaderClient returns a client configured as base, which dials with dial, whose conns
+// announce src in a PROXY v2 header. That includes those to an -upstream proxy.
+func withProxyHeaderClient(base *fasthttp.Client, dial func(network, address string) (net.Conn, error), src net.Addr) *fasthttp.Client {
+	key := proxyHeaderKey{base: base, src: src.String()}
+	proxyHeaderClients.Lock()
+	defer proxyHeaderClients.Unlock()
+	if pc := proxyHeaderClients.m[key]; pc != nil {
+		pc.used = time.Now()
+		return pc.client
+	}
+	client := newHTTPClient(dial)
+	client.ConfigureClient = func(hc *fasthttp.HostClient) error {
+		if base.ConfigureClient != nil {
+			if err := base.ConfigureClient(hc); err != nil {
+				return err
+			}
+		}
+		hcDial := hc.Dial
+		hc.Dial = func(addr string) (net.Conn, error) {
+			c, err := hcDial(addr)
+			if err != nil {
+				return nil, err
+			}
+			return writeProxyHeader(c, src)
+		}
+		return nil
+	}
+	proxyHeaderClients.m[key] = &proxyHeaderClient{client: client, used: time.Now()}
+	return client
+}
+
+// evictProxyHeaderClients periodically drops the clients unused for -idle-timeout,
+// by then the client conn they were for is gone
+func evictProxyHeaderClients(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		proxyHeaderClients.Lock()
+		for key, pc := range proxyHeaderClients.m {
+			if now.Sub(pc.used) > *idleTimeout {
+				pc.client.CloseIdleConnections()
+				delete(proxyHeaderClients.m, key)
+			}
+		}
+		proxyHeaderClients.Unlock()
+	}
+}
+
+// proxyHeaderV2 encodes a PROXY v2 header from src to dst, LOCAL when src is not a TCP address
+func proxyHeaderV2(src, dst net.Addr) []byte {
+	hdr := append([]byte(nil), proxyV2Sig...)
+	s, ok := src.(*net.TCPAddr)
+	if !ok || s == nil {
+		return append(hdr, 0x20, 0x00, 0, 0)
+	}
+	d, _ := dst.(*net.TCPAddr)
+	var dstIP net.IP
+	var dstPort int
+	if d != nil {
+		dstIP, dstPort = d.IP, d.Port
+	}
+
+	var addrs []byte
+	if ip := s.IP.To4(); ip != nil {
+		hdr = append(hdr, 0x21, 0x11, 0, 12)
+		addrs = append(ip, make(net.IP, 4)...)
+		if dip := dstIP.To4(); dip != nil {
+			copy(addrs[4:], dip)
+		}
+	} else {
+		hdr = append(hdr, 0x21, 0x21, 0, 36)
+		addrs = append(s.IP.To16(), make(net.IP, 16)...)
+		if dip := dstIP.To16(); dip != nil && dstIP.To4() == nil {
+			copy(addrs[16:], dip)
+		}
+	}
+	hdr = append(hdr, addrs...)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(s.Port))
+	return binary.BigEndian.AppendUint16(hdr, uint16(dstPort))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestProxyHeaderClientUpstream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := newProxyProtoListener(ln)
+	defer pln.Close()
+	// the client announced by each conn the upstream proxy accepts
+	accepted := make(chan string, 16)
+	requests := make(chan *http.Request, 16)
+	go func() {
+		for {
+			c, err := pln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c.RemoteAddr().String()
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					requests <- req
+					c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}()
+		}
+	}()
+
+	base := newHTTPClient(noDial(t))
+	if err := forwardToHTTPProxy(base, "http://"+ln.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	src := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4321}
+	client := withProxyHeaderClient(base, noDial(t), src)
+	defer client.CloseIdleConnections()
+	if withProxyHeaderClient(base, noDial(t), src) != client {
+		t.Error("no client reuse for the same client address")
+	}
+
+	for i := 0; i < 2; i++ {
+		req := &fasthttp.Request{}
+		req.SetRequestURI("http://example.com/")
+		resp := fasthttp.AcquireResponse()
+		if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		// a streamed response body holds its conn until released
+		fasthttp.ReleaseResponse(resp)
+		select {
+		case got := <-requests:
+			if got.RequestURI != "http://example.com/" {
+				t.Errorf("request %d: upstream got %s, not absolute-form", i, got.RequestURI)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("request %d: nothing reached the upstream", i)
+		}
+	}
+	if got := <-accepted; got != src.String() {
+		t.Errorf("announced %s, want %s", got, src)
+	}
+	select {
+	case <-accepted:
+		t.Error("upstream conn not reused")
+	default:
+	}
+}
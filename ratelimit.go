@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var rateLimit = flag.Int64(`rate-limit`, 0, `Global bandwidth limit in bytes/sec, per user limits go in -user-limit (0: unlimited)`)
+var userLimits = flag.String(`user-limit`, ``, `Per user bandwidth limits in bytes/sec, comma separated user=limit. Eg: alice=1048576,bob=65536`)
+
+// rateLimiter is a token bucket allowing rate bytes/sec with a one second burst
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+var globalLimiter atomic.Pointer[rateLimiter]
+
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+type limitedReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	for _, limiter := range l.limiters {
+		if int64(len(p)) > limiter.rate {
+			p = p[:limiter.rate]
+		}
+	}
+	n, err := l.r.Read(p)
+	for _, limiter := range l.limiters {
+		limiter.wait(n)
+	}
+	return n, err
+}
+
+// userLimiter returns the limiter of user, if any
+func userLimiter(user string) *rateLimiter {
+	if s := proxyAuth.Load(); s != nil {
+		return s.limiters[user]
+	}
+	return nil
+}
+
+func rateLimited(user string) bool {
+	return globalLimiter.Load() != nil || userLimiter(user) != nil
+}
+
+// limitReader throttles r by the global and user limiters
+func limitReader(r io.Reader, user string) io.Reader {
+	var limiters []*rateLimiter
+	if l := globalLimiter.Load(); l != nil {
+		limiters = append(limiters, l)
+	}
+	if l := userLimiter(user); l != nil {
+		limiters = append(limiters, l)
+	}
+	if limiters == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiters: limiters}
+}
+
+// writeDeadlineReader pushes the write deadline of c forward on every read,
+// so throttled response bodies are not cut by the server WriteTimeout
+type writeDeadlineReader struct {
+	r       io.Reader
+	c       net.Conn
+	timeout time.Duration
+}
+
+func (w *writeDeadlineReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.c.SetWriteDeadline(time.Now().Add(w.timeout))
+	return n, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// liveKeys are the config keys a reload applies without a restart
+var liveKeys = map[string]bool{
+	`u`:                   true,
+	`auth-file`:           true,
+	`allow-hosts`:         true,
+	`deny-hosts`:          true,
+	`connect-allow-ports`: true,
+	`allow-methods`:       true,
+	`allow-connect`:       true,
+	`no-connect`:          true,
+	`rate-limit`:          true,
+	`user-limit`:          true,
+	`client-timeout`:      true,
+	`tunnel-idle-timeout`: true,
+}
+
+var liveClientTimeout, liveTunnelIdleTimeout atomic.Int64
+
+// applyLive builds the credentials, ACL, rate limit and timeouts from the flags and swaps them in.
+// Nothing is swapped if any of them fails to load.
+func applyLive() error {
+	auth, err := loadAuth()
+	if err != nil {
+		return err
+	}
+	a, err := loadACL()
+	if err != nil {
+		return err
+	}
+	if *authFile != "" {
+		log.Println("Loaded credentials:", len(auth.auths)+len(auth.hashes))
+	}
+
+	proxyAuth.Store(auth)
+	destACL.Store(a)
+	globalLimiter.Store(newRateLimiter(*rateLimit))
+	liveClientTimeout.Store(int64(*httpClientTimeout))
+	idle := *tunnelIdleTimeout
+	if idle == 0 {
+		idle = *idleTimeout
+	}
+	liveTunnelIdleTimeout.Store(int64(idle))
+	return nil
+}
+
+// watchReload re-reads -config on SIGHUP
+func watchReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(); err != nil {
+			log.Println("Reload config:", err)
+			continue
+		}
+		log.Println("Reloaded config:", *configFile)
+	}
+}
+
+// reloadConfig sets the live flags from -config, resetting keys removed from it to their defaults,
+// and logs changes to other keys as ignored. Command line flags still take precedence.
+func reloadConfig() error {
+	values, err := loadConfig(*configFile)
+	if err != nil {
+		return err
+	}
+	for k := range values {
+		if flag.Lookup(k) == nil {
+			return fmt.Errorf("unknown config key: %s", k)
+		}
+	}
+
+	old := map[string]string{}
+	for k := range liveKeys {
+		old[k] = flag.Lookup(k).Value.String()
+	}
+	restore := func() {
+		for k, v := range old {
+			flag.Set(k, v)
+		}
+	}
+	for k := range liveKeys {
+		if commandLineFlags[k] {
+			continue
+		}
+		v := flag.Lookup(k).DefValue
+		if items, ok := values[k]; ok {
+			v = strings.Join(items, ",")
+		}
+		if err := flag.Set(k, v); err != nil {
+			restore()
+			return fmt.Errorf("config key %s: %v", k, err)
+		}
+	}
+	for k, items := range values {
+		if !liveKeys[k] && !commandLineFlags[k] && !sameValue(flag.Lookup(k).Value.String(), strings.Join(items, ",")) {
+			log.Println("Reload config: ignored", k, "(needs restart)")
+		}
+	}
+	if err := applyLive(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// sameValue compares a flag value with a config value, so 1m equals 1m0s
+func sameValue(cur, v string) bool {
+	if cur == v {
+		return true
+	}
+	a, err1 := time.ParseDuration(cur)
+	b, err2 := time.ParseDuration(v)
+	return err1 == nil && err2 == nil && a == b
+}
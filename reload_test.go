@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// credentials from $PROXY_CREDS must survive a reload of a config without "u"
+func TestReloadKeepsEnvCreds(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(name, []byte("rate-limit: 0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	oldConfig, oldEnv := *configFile, envCreds
+	defer func() {
+		*configFile, envCreds = oldConfig, oldEnv
+		applyLive()
+	}()
+	*configFile = name
+	envCreds = "user:secret"
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if proxyAuth.Load() == nil {
+		t.Fatal("proxy auth disabled after reload")
+	}
+	if user, ok := authenticate([]byte(basicAuth("user:secret"))); !ok || user != "user" {
+		t.Fatalf("authenticate = %q, %v after reload", user, ok)
+	}
+	if _, ok := authenticate([]byte(basicAuth("user:wrong"))); ok {
+		t.Fatal("wrong password accepted")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+)
+
+// SO_REUSEPORT lets a new process bind the port of a running one for a rolling restart:
+// start the new binary with -reuseport, then SIGTERM the old one, which stops accepting and
+// drains. Linux 3.9+ spreads new connections over all sockets bound to the port. The BSDs
+// and macOS allow the bind too, but may keep handing connections to one of the sockets.
+// Elsewhere the flag is logged as unsupported and ignored.
+var reusePort = flag.Bool(`reuseport`, false, `Set SO_REUSEPORT on TCP listeners, so an upgraded process can listen on the same port while the old one drains (linux, BSD, macOS)`)
+
+// listenTCP listens on addr, with SO_REUSEPORT if -reuseport
+func listenTCP(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if *reusePort {
+		lc.Control = reusePortControl
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
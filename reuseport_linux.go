@@ -0,0 +1,6 @@
+//go:build linux && (386 || amd64 || arm)
+
+package main
+
+// SO_REUSEPORT, missing from syscall on these ports
+const soReusePort = 0xf
@@ -0,0 +1,17 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package main
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+)
+
+func reusePortSupported() error {
+	return errors.New("SO_REUSEPORT is not supported on " + runtime.GOOS)
+}
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return reusePortSupported()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var reverseSpec = flag.String(`reverse`, ``, `Reverse proxy origin-form requests for a Host to a backend, keeping the path, comma separated host=backend URL. Such requests skip proxy auth. Eg: app.example.com=http://127.0.0.1:8080,static.example.com=http://10.0.0.5/static`)
+
+// reverseRoutes maps a Host, with or without its port, to its backend
+var reverseRoutes map[string]*url.URL
+
+func parseReverseRoutes(spec string) (map[string]*url.URL, error) {
+	routes := make(map[string]*url.URL)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		host, backend, ok := strings.Cut(part, "=")
+		if !ok || host == "" {
+			return nil, fmt.Errorf("invalid reverse rule: %q", part)
+		}
+		u, err := url.Parse(backend)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid reverse backend: %q", backend)
+		}
+		routes[strings.ToLower(host)] = u
+	}
+	return routes, nil
+}
+
+// reverseBackend returns the backend of an origin-form request by its Host, or nil
+func reverseBackend(ctx *fasthttp.RequestCtx) *url.URL {
+	if len(reverseRoutes) == 0 || ctx.IsConnect() {
+		return nil
+	}
+	if uri := ctx.Request.Header.RequestURI(); len(uri) == 0 || uri[0] != '/' {
+		return nil
+	}
+	host := strings.ToLower(string(ctx.Request.Header.Host()))
+	if u, ok := reverseRoutes[host]; ok {
+		return u
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return reverseRoutes[h]
+	}
+	return nil
+}
+
+// reverseProxy forwards the request to backend under its path, with the backend as Host.
+// Redirects to the backend are pointed back at the requested host.
+// A streamed response writes al once sent, see streamBody.
+func reverseProxy(ctx *fasthttp.RequestCtx, backend *url.URL, al *accessLog) (streaming bool, err error) {
+	req := &ctx.Request
+	origHost := string(req.Header.Host())
+	origScheme := "http"
+	if ctx.IsTLS() {
+		origScheme = "https"
+	}
+	// copying the backend response resets the flag, see requestHandler
+	closeConn := req.Header.ConnectionClose()
+	stripHopHeaders(&req.Header)
+	if *forwardedFor {
+		addForwardedHeaders(&req.Header, ctx.RemoteIP().String())
+		req.Header.Set("X-Forwarded-Host", origHost)
+		req.Header.Set("X-Forwarded-Proto", origScheme)
+	}
+	timeout := requestTimeout(&req.Header)
+	rewriteHeaders(&req.Header)
+
+	uri := req.URI()
+	uri.SetScheme(backend.Scheme)
+	uri.SetHost(backend.Host)
+	uri.SetPath(strings.TrimSuffix(backend.Path, "/") + string(uri.Path()))
+	req.Header.SetHost(backend.Host)
+
+	metricHTTPBytesIn.Add(int64(len(req.Body())))
+	done := upstreamRequest(fasthttp.AddMissingPort(backend.Host, backend.Scheme == "https"))
+	resp := fasthttp.AcquireResponse()
+	err = httpClientLocal.DoTimeout(req, resp, timeout)
+	if err == nil {
+		streaming, err = streamResponse(resp)
+	}
+	if err != nil {
+		done()
+		fasthttp.ReleaseResponse(resp)
+		return false, err
+	}
+
+	if loc, err := url.Parse(string(resp.Header.Peek("Location"))); err == nil && strings.EqualFold(loc.Host, backend.Host) {
+		loc.Scheme, loc.Host = origScheme, origHost
+		loc.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(loc.Path, strings.TrimSuffix(backend.Path, "/")), "/")
+		resp.Header.Set("Location", loc.String())
+	}
+	if streaming {
+		al.Received = int64(len(req.Body()))
+		streamBody(ctx, resp, al, timeout, done)
+	} else {
+		done()
+		resp.CopyTo(&ctx.Response)
+		fasthttp.ReleaseResponse(resp)
+		metricHTTPBytesOut.Add(int64(len(ctx.Response.Body())))
+	}
+	if closeConn {
+		ctx.SetConnectionClose()
+	}
+	return streaming, nil
+}
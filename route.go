@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var routeSpec = flag.String(`route`, ``, `Route destination hosts elsewhere, comma separated host[:port]=target. Eg: internal.svc=unix:/tmp/app.sock,api.local=127.0.0.1:8080`)
+
+// parseRoutes maps a normalized host or host:port to a unix:path or host[:port] target
+func parseRoutes(spec string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		host, target, ok := strings.Cut(part, "=")
+		if !ok || host == "" || target == "" || target == "unix:" {
+			return nil, fmt.Errorf("invalid route: %q", part)
+		}
+		routes[normalizeHost(host)] = target
+	}
+	return routes, nil
+}
+
+// routingDial dials the route of an address, matched by host:port then host,
+// and dials unmatched addresses normally
+func routingDial(routes map[string]string, dial func(network, address string) (net.Conn, error)) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(network, address)
+		}
+		host = normalizeHost(host)
+		target, ok := routes[net.JoinHostPort(host, port)]
+		if !ok {
+			target, ok = routes[host]
+		}
+		if !ok {
+			return dial(network, address)
+		}
+		if path, isUnix := strings.CutPrefix(target, "unix:"); isUnix {
+			return netDialer.Dial("unix", path)
+		}
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, port)
+		}
+		return dial(network, target)
+	}
+}
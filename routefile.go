@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+var routeFile = flag.String(`route-file`, ``, `File of "pattern upstream" lines choosing how to reach a destination, first match wins. Patterns are a host, *.suffix, a CIDR of IP destinations or * for all. Upstream is direct or an http:// or socks5:// proxy URL. Unmatched destinations use -upstream, -socks5-upstream or -r if set`)
+
+type routeRule struct {
+	hosts *hostList // nil matches all
+	dial  func(network, address string) (net.Conn, error)
+}
+
+// proxyURLDialer dials through the http:// or socks5:// proxy at target
+func proxyURLDialer(target string) (func(network, address string) (net.Conn, error), error) {
+	switch {
+	case strings.HasPrefix(target, "http://"):
+		return httpProxyDialer(target)
+	case strings.HasPrefix(target, "socks5://"):
+		return socks5Dialer(target)
+	}
+	return nil, fmt.Errorf("invalid upstream: %q", target)
+}
+
+// loadRouteRules reads -route-file, direct is the dial of "direct" rules
+func loadRouteRules(name string, direct func(network, address string) (net.Conn, error)) ([]routeRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []routeRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want pattern and upstream: %q", name, n, line)
+		}
+		var r routeRule
+		if fields[0] != "*" {
+			r.hosts = &hostList{exact: make(map[string]bool)}
+			if err := r.hosts.add(fields[0]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+			}
+		}
+		if fields[1] == "direct" {
+			r.dial = direct
+		} else if r.dial, err = proxyURLDialer(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, n, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// ruleDial dials an address with the first rule matching its host, or with dial
+func ruleDial(rules []routeRule, dial func(network, address string) (net.Conn, error)) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(network, address)
+		}
+		for _, r := range rules {
+			if r.hosts == nil || r.hosts.match(host) {
+				return r.dial(network, address)
+			}
+		}
+		return dial(network, address)
+	}
+}
@@ -0,0 +1,38 @@
+package shared
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var ACMEDomains = flag.String(`acme-domains`, ``, `Get certificates from Let's Encrypt for these domains instead of -cert/-key. Eg: proxy.example.com,www.example.com`)
+var ACMEEmail = flag.String(`acme-email`, ``, `ACME account contact email`)
+var ACMECacheDir = flag.String(`acme-cache-dir`, `acme-cache`, `Directory to cache ACME certificates`)
+var ACMEHTTP = flag.String(`acme-http`, `:80`, `Listen address for ACME HTTP-01 challenges`)
+
+// ACMETLSConfig returns a tls config obtaining and renewing certificates automatically,
+// and starts the HTTP-01 challenge server
+func ACMETLSConfig() *tls.Config {
+	var domains []string
+	for _, d := range strings.Split(*ACMEDomains, `,`) {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(*ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      *ACMEEmail,
+	}
+	go func() {
+		log.Println("ACME challenge:", http.ListenAndServe(*ACMEHTTP, m.HTTPHandler(nil)))
+	}()
+	log.Println("ACME domains:", domains)
+	return &tls.Config{GetCertificate: m.GetCertificate}
+}
@@ -0,0 +1,163 @@
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var CertWatch = flag.Duration(`cert-watch`, time.Minute, `Interval to check -cert/-key and -cert-dir for changes, 0 to disable. SIGHUP also reloads them`)
+var CertDir = flag.String(`cert-dir`, ``, `Directory of name.crt/name.key pairs chosen by SNI against their CN and SANs. Unmatched names get -cert/-key, or the first pair`)
+
+// CertHolder serves the current certificates and swaps them on reload.
+// Connections already handshaked keep the certificate they got.
+type CertHolder struct {
+	certFile, keyFile, dir string
+	cert                   atomic.Pointer[tls.Certificate]
+	byName                 atomic.Pointer[map[string]*tls.Certificate]
+	modTime                time.Time
+}
+
+// NewCertHolder loads certFile/keyFile and the pairs in dir, either may be empty
+func NewCertHolder(certFile, keyFile, dir string) (*CertHolder, error) {
+	h := &CertHolder{certFile: certFile, keyFile: keyFile, dir: dir}
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *CertHolder) load() error {
+	modTime := h.lastModified()
+	var def *tls.Certificate
+	if h.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(h.certFile, h.keyFile)
+		if err != nil {
+			return err
+		}
+		def = &cert
+	}
+	byName := make(map[string]*tls.Certificate)
+	if h.dir != "" {
+		first, err := loadCertDir(h.dir, byName)
+		if err != nil {
+			return err
+		}
+		if def == nil {
+			def = first
+		}
+	}
+	if def == nil {
+		return errors.New("no certificate")
+	}
+	h.modTime = modTime
+	h.byName.Store(&byName)
+	h.cert.Store(def)
+	return nil
+}
+
+// loadCertDir adds every name.crt/name.key pair of dir to byName under its CN and DNS SANs,
+// returning the first pair by file name
+func loadCertDir(dir string, byName map[string]*tls.Certificate) (*tls.Certificate, error) {
+	crts, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(crts)
+	var first *tls.Certificate
+	for _, crt := range crts {
+		cert, err := tls.LoadX509KeyPair(crt, strings.TrimSuffix(crt, ".crt")+".key")
+		if err != nil {
+			return nil, err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		cert.Leaf = leaf
+		names := leaf.DNSNames
+		if leaf.Subject.CommonName != "" {
+			names = append([]string{leaf.Subject.CommonName}, names...)
+		}
+		for _, name := range names {
+			byName[strings.ToLower(name)] = &cert
+		}
+		if first == nil {
+			first = &cert
+		}
+	}
+	if first == nil {
+		return nil, errors.New(dir + ": no .crt/.key pairs")
+	}
+	return first, nil
+}
+
+// lastModified returns the newest mtime of the cert and key files, and of -cert-dir and its pairs
+func (h *CertHolder) lastModified() (t time.Time) {
+	names := []string{h.certFile, h.keyFile}
+	if h.dir != "" {
+		crts, _ := filepath.Glob(filepath.Join(h.dir, "*.crt"))
+		keys, _ := filepath.Glob(filepath.Join(h.dir, "*.key"))
+		names = append(append(append(names, h.dir), crts...), keys...)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return
+}
+
+// GetCertificate picks a -cert-dir certificate by exact SNI, then by *.parent wildcard
+func (h *CertHolder) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	byName := *h.byName.Load()
+	if name := strings.ToLower(hello.ServerName); name != "" && len(byName) != 0 {
+		if cert, ok := byName[name]; ok {
+			return cert, nil
+		}
+		if _, parent, ok := strings.Cut(name, "."); ok {
+			if cert, ok := byName["*."+parent]; ok {
+				return cert, nil
+			}
+		}
+	}
+	return h.cert.Load(), nil
+}
+
+// Watch reloads the certificate on SIGHUP or when the files change.
+// A failed reload keeps the previous certificate.
+func (h *CertHolder) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			if !h.lastModified().After(h.modTime) {
+				continue
+			}
+		}
+		if err := h.load(); err != nil {
+			log.Println("Reload certificate:", err)
+			continue
+		}
+		log.Println("Reloaded certificates:", h.certFile, h.dir)
+	}
+}
@@ -0,0 +1,3 @@
+// Package shared is the TLS, systemd and environment setup common to http-proxy-server and tls-server.
+// Importing it registers the flags it reads.
+package shared
@@ -0,0 +1,19 @@
+package shared
+
+import (
+	"os"
+	"strings"
+)
+
+// CredsFromEnv returns the contents of the file named by the fileEnv variable,
+// or else the value of the env variable
+func CredsFromEnv(fileEnv, env string) (string, error) {
+	if name := os.Getenv(fileEnv); name != "" {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return os.Getenv(env), nil
+}
@@ -0,0 +1,10 @@
+module shared
+
+go 1.20
+
+require golang.org/x/crypto v0.7.0
+
+require (
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+)
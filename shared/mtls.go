@@ -0,0 +1,43 @@
+package shared
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var ClientCA = flag.String(`client-ca`, ``, `Require TLS client certificates signed by this CA file, in addition to any other auth. Eg: ca.pem`)
+
+// RequireClientCerts makes config ask for and verify client certificates against -client-ca
+func RequireClientCerts(config *tls.Config) error {
+	pem, err := os.ReadFile(*ClientCA)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s: no certificates found", *ClientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// CertIdentity names the verified client certificate of cs by its CN, or its first DNS or email SAN
+func CertIdentity(cs *tls.ConnectionState) string {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return ""
+	}
+	cert := cs.PeerCertificates[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) != 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) != 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var tlsMinVersion = flag.String(`tls-min-version`, `1.2`, `Minimum TLS version: 1.0, 1.1, 1.2 or 1.3`)
+var tlsCiphers = flag.String(`tls-ciphers`, ``, `Comma separated TLS 1.0-1.2 cipher suites. Eg: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (default: Go's secure set)`)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ApplyTLSOptions sets -tls-min-version and -tls-ciphers on config
+func ApplyTLSOptions(config *tls.Config) error {
+	v, ok := tlsVersions[*tlsMinVersion]
+	if !ok {
+		return fmt.Errorf("unknown -tls-min-version: %q", *tlsMinVersion)
+	}
+	config.MinVersion = v
+	if *tlsCiphers == "" {
+		return nil
+	}
+
+	suites := make(map[string]*tls.CipherSuite)
+	for _, s := range tls.CipherSuites() {
+		suites[s.Name] = s
+	}
+	config.CipherSuites = nil
+	for _, name := range strings.Split(*tlsCiphers, ",") {
+		name = strings.TrimSpace(name)
+		s, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("unknown or insecure cipher suite: %q", name)
+		}
+		if len(s.SupportedVersions) == 1 && s.SupportedVersions[0] == tls.VersionTLS13 {
+			return fmt.Errorf("TLS 1.3 cipher suites are not configurable: %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, s.ID)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package shared
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ListenUnix listens on the unix socket path, replacing a stale socket file.
+// With check the path is left alone, as a running instance may be serving on it:
+// the listener is on a temporary name in the same directory, unlinked on Close.
+func ListenUnix(path string, check bool) (net.Listener, error) {
+	if check {
+		return net.Listen(`unix`, filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".check-"+strconv.Itoa(os.Getpid())))
+	}
+	os.Remove(path)
+	ln, err := net.Listen(`unix`, path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, os.ModePerm)
+	return ln, nil
+}
@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixCheck(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.sock")
+	live, err := ListenUnix(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	ln, err := ListenUnix(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	if _, err = os.Stat(path); err != nil {
+		t.Fatalf("live socket removed by the check: %v", err)
+	}
+	c, err := net.Dial(`unix`, path)
+	if err != nil {
+		t.Fatalf("live socket unreachable after the check: %v", err)
+	}
+	c.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("check left files behind: %v", entries)
+	}
+
+	if _, err = ListenUnix(filepath.Join(dir, "missing", "proxy.sock"), true); err == nil {
+		t.Error("no error for a missing directory")
+	}
+}
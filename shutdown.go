@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var shutdownTimeout = flag.Duration(`shutdown-timeout`, 30*time.Second, `Graceful shutdown timeout. Eg: 30s`)
+var drainTimeout = flag.Duration(`drain-timeout`, 30*time.Second, `Time open tunnels get to finish on shutdown before they are closed`)
+
+// errShuttingDown refuses new tunnels once shutdown starts
+var errShuttingDown = errors.New("shutting down")
+
+// shuttingDown is set once a shutdown signal is received
+var shuttingDown atomic.Bool
+
+// openTunnel is a running tunnel, up and down are nil for intercepted ones
+type openTunnel struct {
+	client, remote net.Conn
+	dest           string
+	al             *accessLog
+	up, down       *countReader
+}
+
+// openTunnels holds the running tunnels so a shutdown can close them and -ui-listen list them
+var openTunnels = struct {
+	sync.Mutex
+	m map[*openTunnel]struct{}
+}{m: make(map[*openTunnel]struct{})}
+
+// trackTunnel registers t until the returned func is called
+func trackTunnel(t *openTunnel) func() {
+	openTunnels.Lock()
+	openTunnels.m[t] = struct{}{}
+	openTunnels.Unlock()
+	return func() {
+		openTunnels.Lock()
+		delete(openTunnels.m, t)
+		openTunnels.Unlock()
+	}
+}
+
+// stopTunnels refuses new tunnels and returns a channel closed once the open ones are released.
+// It holds the lock of acquireTunnel, so no tunnel opens unnoticed.
+func stopTunnels() <-chan struct{} {
+	tunnels.Lock()
+	defer tunnels.Unlock()
+	shuttingDown.Store(true)
+	done := make(chan struct{})
+	if tunnels.n == 0 {
+		close(done)
+	} else {
+		tunnels.drained = done
+	}
+	return done
+}
+
+// closeTunnels closes the conns of all running tunnels, returning how many there were
+func closeTunnels() int {
+	openTunnels.Lock()
+	defer openTunnels.Unlock()
+	for t := range openTunnels.m {
+		t.client.Close()
+		t.remote.Close()
+	}
+	return len(openTunnels.m)
+}
+
+// waitShutdown blocks until SIGINT/SIGTERM, then shuts the server down gracefully.
+// A second signal exits immediately.
+func waitShutdown(shutdown func(ctx context.Context) error) {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Println("Shutting down:", <-sig)
+	done := stopTunnels()
+	go func() {
+		log.Println("Force exit:", <-sig)
+		os.Exit(1)
+	}()
+
+	drain := time.NewTimer(*drainTimeout)
+	defer drain.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		log.Println("Shutdown:", err)
+	}
+
+	select {
+	case <-done:
+		return
+	case <-drain.C:
+	}
+	log.Println("Drain timeout, force closed tunnels:", closeTunnels())
+	// let the relays log and return
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
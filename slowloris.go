@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var headerTimeout = flag.Duration(`header-timeout`, 0, `Time allowed for the request line and headers, against clients sending them slowly. -read-timeout then applies to the body alone. Too slow clients get 408. Eg: 3s (default: -read-timeout covers both)`)
+
+var minRequestRate = flag.Int64(`min-request-rate`, 0, `Minimum bytes/sec a client must keep up while its request line, headers and body are read, after a first second of grace. Slower clients get 408. Eg: 512 (default: no minimum)`)
+
+var metricSlowRequests = newCounter("proxy_slow_requests_total", "Requests rejected with 408 for sending their headers or body too slowly")
+
+// serverReadTimeout is the read deadline set when a request starts
+func serverReadTimeout() time.Duration {
+	if *headerTimeout > 0 {
+		return *headerTimeout
+	}
+	return *readTimeout
+}
+
+// headerReceived gives the body its own -read-timeout once the headers came within -header-timeout
+func headerReceived(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+	if *headerTimeout > 0 {
+		return fasthttp.RequestConfig{ReadTimeout: *readTimeout}
+	}
+	return fasthttp.RequestConfig{}
+}
+
+// firstRequestListener bounds the wait for the first request of accepted conns,
+// fasthttp only sets its read timeout once a first byte arrived
+type firstRequestListener struct {
+	net.Listener
+}
+
+func (l firstRequestListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil && serverReadTimeout() > 0 {
+		c.SetReadDeadline(time.Now().Add(serverReadTimeout()))
+	}
+	return c, err
+}
+
+// minRateListener wraps accepted conns in a minRateConn under -min-request-rate
+type minRateListener struct {
+	net.Listener
+}
+
+func (l minRateListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &minRateConn{Conn: c, rate: *minRequestRate}, nil
+}
+
+// minRateConn keeps a read deadline by which the client must have sent rate bytes/sec
+// while a request is read, extended with every read. The server's own deadline still applies.
+type minRateConn struct {
+	net.Conn
+	rate int64
+
+	mu       sync.Mutex
+	reading  bool
+	start    time.Time
+	n        int64
+	deadline time.Time
+	slow     bool
+}
+
+// limit is the read deadline in effect while reading a request
+func (c *minRateConn) limit() time.Time {
+	t := c.start.Add(time.Second + time.Duration(c.n)*time.Second/time.Duration(c.rate))
+	if !c.deadline.IsZero() && c.deadline.Before(t) {
+		return c.deadline
+	}
+	return t
+}
+
+// beginRequest starts measuring the rate of a request
+func (c *minRateConn) beginRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reading, c.start, c.n, c.slow = true, time.Now(), 0, false
+	c.Conn.SetReadDeadline(c.limit())
+}
+
+// endRequest gives the server its own deadline back once the request is read
+func (c *minRateConn) endRequest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reading {
+		c.reading = false
+		c.Conn.SetReadDeadline(c.deadline)
+	}
+}
+
+// isSlow reports whether the last read timed out on the -min-request-rate deadline
+func (c *minRateConn) isSlow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.slow
+}
+
+func (c *minRateConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	if c.reading {
+		c.n += int64(n)
+		if errors.Is(err, os.ErrDeadlineExceeded) && (c.deadline.IsZero() || time.Now().Before(c.deadline)) {
+			c.slow = true
+		} else if n > 0 {
+			c.Conn.SetReadDeadline(c.limit())
+		}
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *minRateConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	if c.reading {
+		t = c.limit()
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *minRateConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+func (c *minRateConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *minRateConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+// asMinRateConn finds the minRateConn under c, if any
+func asMinRateConn(c net.Conn) *minRateConn {
+	for {
+		if mc, ok := c.(*minRateConn); ok {
+			return mc
+		}
+		u, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		c = u.NetConn()
+	}
+}
+
+// requestConnState starts the -min-request-rate measure of a request once its first byte came.
+// The handler ends it, see requestRead.
+func requestConnState(c net.Conn, state fasthttp.ConnState) {
+	if state != fasthttp.StateActive {
+		return
+	}
+	if mc := asMinRateConn(c); mc != nil {
+		mc.beginRequest()
+	}
+}
+
+// serverConnState also counts the open client conns of the servers of listeners,
+// ServeConn gives no StateNew
+func serverConnState(c net.Conn, state fasthttp.ConnState) {
+	switch state {
+	case fasthttp.StateNew:
+		metricOpenConns.Add(1)
+	case fasthttp.StateHijacked, fasthttp.StateClosed:
+		metricOpenConns.Add(-1)
+	}
+	requestConnState(c, state)
+}
+
+// h2ConnState counts the open client conns of h2 servers
+func h2ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		metricOpenConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		metricOpenConns.Add(-1)
+	}
+}
+
+// requestRead stops the -min-request-rate measure once the handler has the whole request
+func requestRead(ctx *fasthttp.RequestCtx) {
+	if mc := asMinRateConn(ctx.Conn()); mc != nil {
+		mc.endRequest()
+	}
+}
+
+// rejectSlow counts and logs a request that timed out being read with err
+func rejectSlow(ctx *fasthttp.RequestCtx, err error) {
+	metricSlowRequests.Add(1)
+	if mc := asMinRateConn(ctx.Conn()); mc != nil && mc.isSlow() {
+		log.Println("Reject: request below -min-request-rate", ctx.RemoteIP(), mc.rate)
+		return
+	}
+	// fasthttp wraps errors reading headers, not the body. Nothing read leaves the headers empty.
+	if _, body := err.(*net.OpError); !body || len(ctx.Request.Header.RequestURI()) == 0 {
+		log.Println("Reject: headers too slow", ctx.RemoteIP(), serverReadTimeout())
+	} else {
+		log.Println("Reject: body too slow", ctx.RemoteIP(), *readTimeout)
+	}
+}
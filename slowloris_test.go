@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMinRequestRateTrickle(t *testing.T) {
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+	old := *minRequestRate
+	*minRequestRate = 100
+	defer func() {
+		*minRequestRate = old
+	}()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(true)
+	go srv.Serve(minRateListener{ln})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	before := metricSlowRequests.Load()
+	start := time.Now()
+	// a byte every 200ms is well below 100 bytes/sec, but within -read-timeout
+	go func() {
+		for _, b := range []byte("GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n") {
+			if _, err := c.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp := &fasthttp.Response{}
+	if err = resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusRequestTimeout {
+		t.Errorf("status %d, want 408", resp.StatusCode())
+	}
+	if d := time.Since(start); d >= *readTimeout {
+		t.Errorf("rejected after %v, not before -read-timeout", d)
+	}
+	if metricSlowRequests.Load() != before+1 {
+		t.Error("slow request not counted")
+	}
+}
+
+func TestMinRequestRateIdleKeepAlive(t *testing.T) {
+	dial, _, _ := testOrigin(t)
+	// only for its dial to the origin, the server under test runs on TCP
+	testProxy(t, dial)
+	old := *minRequestRate
+	*minRequestRate = 100
+	defer func() {
+		*minRequestRate = old
+	}()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(true)
+	go srv.Serve(minRateListener{ln})
+	defer srv.Shutdown()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	raw := "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	for i := 0; i < 2; i++ {
+		if resp := exchange(t, c, br, raw); resp.StatusCode() != fasthttp.StatusOK {
+			t.Fatalf("request %d: status %d", i, resp.StatusCode())
+		}
+		// idle time between requests is not measured
+		time.Sleep(1500 * time.Millisecond)
+	}
+}
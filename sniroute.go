@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var sniRouteSpec = flag.String(`sni-route`, ``, `Send traffic of TLS clients through an upstream proxy chosen by their SNI, comma separated sni=proxy URL. Eg: a.example.com=http://10.0.0.1:3128,*.b.example.com=socks5://10.0.0.2:1080`)
+
+// sniUpstream dials CONNECT targets and forwards HTTP requests through one upstream proxy
+type sniUpstream struct {
+	dial   func(network, address string) (net.Conn, error)
+	client *fasthttp.Client
+}
+
+// sniRoutes maps an SNI, or *.suffix, to its upstream
+var sniRoutes map[string]*sniUpstream
+
+func parseSNIRoutes(spec string) (map[string]*sniUpstream, error) {
+	routes := make(map[string]*sniUpstream)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(part, "=")
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("invalid sni route: %q", part)
+		}
+		dial, err := proxyURLDialer(target)
+		if err != nil {
+			return nil, err
+		}
+		if *dialRetries > 0 {
+			dial = retryingDial(dial, *dialRetries)
+		}
+		routes[strings.ToLower(name)] = &sniUpstream{dial: dial, client: newHTTPClient(dial)}
+	}
+	return routes, nil
+}
+
+// upstreamFor returns the dial func and HTTP client for a conn by its SNI,
+// the defaults when it is not TLS or no route matches
+func upstreamFor(cs *tls.ConnectionState) (func(network, address string) (net.Conn, error), *fasthttp.Client) {
+	if cs != nil && len(sniRoutes) != 0 {
+		name := strings.ToLower(cs.ServerName)
+		u, ok := sniRoutes[name]
+		for !ok && name != "" {
+			_, name, _ = strings.Cut(name, ".")
+			u, ok = sniRoutes["*."+name]
+		}
+		if ok {
+			return u.dial, u.client
+		}
+	}
+	return localDialFunc, httpClientLocal
+}
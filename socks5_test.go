@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// socks5Connect sends a no-auth CONNECT for the domain host to handleSocks5 and returns the reply code
+func socks5Connect(t *testing.T, host string, port uint16) byte {
+	t.Helper()
+	if err := applyLive(); err != nil {
+		t.Fatal(err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			handleSocks5(c)
+		}
+	}()
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err = c.Write([]byte{socks5Version, 1, socks5AuthNone}); err != nil {
+		t.Fatal(err)
+	}
+	var method [2]byte
+	if _, err = io.ReadFull(c, method[:]); err != nil {
+		t.Fatal(err)
+	}
+	req := []byte{socks5Version, socks5CmdConnect, 0x00, socks5AtypDomain, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err = c.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	var reply [10]byte
+	if _, err = io.ReadFull(c, reply[:]); err != nil {
+		t.Fatalf("%q: %v", host, err)
+	}
+	return reply[1]
+}
+
+func TestSocks5InvalidHost(t *testing.T) {
+	oldDial := localDialFunc
+	defer func() {
+		localDialFunc = oldDial
+	}()
+	localDialFunc = noDial(t)
+	for _, host := range []string{
+		"example.com:443 HTTP/1.1\r\nX-Injected: 1\r\n\r\nCONNECT example.com",
+		"ex\r\nample.com",
+		"exa mple.com",
+	} {
+		if rep := socks5Connect(t, host, 443); rep != 0x08 {
+			t.Errorf("%q: reply %d, want 8", host, rep)
+		}
+	}
+	if rep := socks5Connect(t, "example.com", 0); rep != 0x08 {
+		t.Errorf("port 0: reply %d, want 8", rep)
+	}
+}
+
+func TestSocks5ConnectDisabled(t *testing.T) {
+	oldDial, oldNoConnect := localDialFunc, *noConnect
+	defer func() {
+		localDialFunc, *noConnect = oldDial, oldNoConnect
+		applyLive()
+	}()
+	localDialFunc = noDial(t)
+	*noConnect = true
+	if rep := socks5Connect(t, "example.com", 443); rep != 0x02 {
+		t.Errorf("reply %d, want 2", rep)
+	}
+}
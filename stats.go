@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var adminListen = flag.String(`admin-listen`, ``, `Admin listen address serving /stats, a bare :port binds to localhost. Eg: :9102`)
+var adminToken = flag.String(`admin-token`, ``, `Bearer token required by the admin listener`)
+
+// distinct destination hosts tracked for /stats
+const maxStatsHosts = 10000
+
+type userStats struct {
+	Requests int64 `json:"requests"`
+	Sent     int64 `json:"sent"`
+	Received int64 `json:"received"`
+}
+
+type hostStats struct {
+	Host     string `json:"host"`
+	Requests int64  `json:"requests"`
+}
+
+var stats = struct {
+	sync.Mutex
+	users map[string]*userStats
+	hosts map[string]int64
+}{users: make(map[string]*userStats), hosts: make(map[string]int64)}
+
+// recordStats adds a finished request to the per-user and per-host stats
+func recordStats(l *accessLog) {
+	user := l.User
+	if user == "" {
+		user = "-"
+	}
+	host := l.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	stats.Lock()
+	defer stats.Unlock()
+	u := stats.users[user]
+	if u == nil {
+		u = &userStats{}
+		stats.users[user] = u
+	}
+	u.Requests++
+	u.Sent += l.Sent
+	u.Received += l.Received
+	if host == "" {
+		return
+	}
+	if _, ok := stats.hosts[host]; !ok && len(stats.hosts) >= maxStatsHosts {
+		// make room by forgetting hosts seen once
+		for h, n := range stats.hosts {
+			if n <= 1 {
+				delete(stats.hosts, h)
+			}
+		}
+		if len(stats.hosts) >= maxStatsHosts {
+			return
+		}
+	}
+	stats.hosts[host]++
+}
+
+type adminStats struct {
+	Uptime         float64                      `json:"uptime"`
+	Connections    int32                        `json:"connections"`
+	ActiveTunnels  int64                        `json:"active_tunnels"`
+	Requests       int64                        `json:"requests"`
+	TunnelSent     int64                        `json:"tunnel_sent"`
+	TunnelReceived int64                        `json:"tunnel_received"`
+	Users          map[string]*userStats        `json:"users"`
+	TopHosts       []hostStats                  `json:"top_hosts"`
+	UpstreamConns  map[string]upstreamConnStats `json:"upstream_conns"`
+}
+
+func snapshotStats(servers []*fasthttp.Server) adminStats {
+	st := adminStats{
+		Uptime:         time.Since(startTime).Seconds(),
+		Connections:    openConns(servers),
+		ActiveTunnels:  metricActiveTunnels.Load(),
+		Requests:       metricRequests.Load(),
+		TunnelSent:     tunnelBytesDown.Load(),
+		TunnelReceived: tunnelBytesUp.Load(),
+		Users:          make(map[string]*userStats),
+		UpstreamConns:  snapshotUpstreamConns(),
+	}
+	stats.Lock()
+	for name, u := range stats.users {
+		c := *u
+		st.Users[name] = &c
+	}
+	for h, n := range stats.hosts {
+		st.TopHosts = append(st.TopHosts, hostStats{Host: h, Requests: n})
+	}
+	stats.Unlock()
+	sort.Slice(st.TopHosts, func(i, j int) bool {
+		if st.TopHosts[i].Requests != st.TopHosts[j].Requests {
+			return st.TopHosts[i].Requests > st.TopHosts[j].Requests
+		}
+		return st.TopHosts[i].Host < st.TopHosts[j].Host
+	})
+	if len(st.TopHosts) > 10 {
+		st.TopHosts = st.TopHosts[:10]
+	}
+	return st
+}
+
+// adminAddr binds a bare :port to localhost
+func adminAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "127.0.0.1" + addr
+	}
+	return addr
+}
+
+// serveAdmin serves /stats on its own listener, never through the proxy
+func serveAdmin(addr string, servers []*fasthttp.Server) {
+	addr = adminAddr(addr)
+	log.Println(`Admin listening:`, addr)
+	log.Panicln(fasthttp.ListenAndServe(addr, func(ctx *fasthttp.RequestCtx) {
+		token, ok := strings.CutPrefix(string(ctx.Request.Header.Peek("Authorization")), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(*adminToken)) != 1 {
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+		if string(ctx.Path()) != "/stats" {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			return
+		}
+		ctx.SetContentType("application/json")
+		enc := json.NewEncoder(ctx)
+		enc.SetIndent("", "  ")
+		enc.Encode(snapshotStats(servers))
+	}))
+}
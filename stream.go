@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// responses larger than this, chunked ones and event streams are relayed as they arrive
+const streamThreshold = 1 << 20
+
+// streamResponse reports whether resp, read by a client with StreamResponseBody, is relayed
+// as it arrives. Other responses are read whole so they can be compressed, cached and rate limited.
+func streamResponse(resp *fasthttp.Response) (bool, error) {
+	if !resp.IsBodyStream() {
+		return false, nil
+	}
+	if cl := resp.Header.ContentLength(); cl == -1 || cl > streamThreshold ||
+		bytes.HasPrefix(resp.Header.ContentType(), []byte("text/event-stream")) {
+		return true, nil
+	}
+	return false, readBody(resp)
+}
+
+// readBody reads the streamed body of resp whole
+func readBody(resp *fasthttp.Response) error {
+	if !resp.IsBodyStream() {
+		return nil
+	}
+	body, err := io.ReadAll(resp.BodyStream())
+	if err != nil {
+		// the client would reuse a conn left mid-body
+		if c := upstreamConnAt(resp.LocalAddr()); c != nil {
+			c.Close()
+		}
+		resp.CloseBodyStream()
+		return err
+	}
+	resp.SetBody(body)
+	return nil
+}
+
+// streamedBody is an upstream body relayed to the client, see streamBody
+type streamedBody struct {
+	io.Reader
+	resp  *fasthttp.Response
+	conn  *upstreamConn
+	eof   bool
+	close func()
+}
+
+func (b *streamedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *streamedBody) Close() error {
+	if b.conn != nil {
+		b.conn.streamIdle.Store(0)
+		if !b.eof {
+			// the client would reuse a conn left mid-body
+			b.conn.Close()
+		}
+	}
+	err := b.resp.CloseBodyStream()
+	fasthttp.ReleaseResponse(b.resp)
+	b.close()
+	return err
+}
+
+// streamBody sends resp to the client of ctx with its body read as it arrives,
+// for as long as the origin sends something within idle. Once the body is sent,
+// or the client or origin goes away, done is called with al.Sent set and al written.
+func streamBody(ctx *fasthttp.RequestCtx, resp *fasthttp.Response, al *accessLog, idle time.Duration, done func()) {
+	conn := upstreamConnAt(resp.LocalAddr())
+	if conn != nil {
+		conn.streamIdle.Store(int64(idle))
+	}
+	counted := &countReader{r: resp.BodyStream()}
+	var r io.Reader = counted
+	if rateLimited(al.User) {
+		r = limitReader(r, al.User)
+	}
+	// the server's -write-timeout covers writing the whole response otherwise
+	r = &writeDeadlineReader{r: r, c: ctx.Conn(), timeout: *writeTimeout}
+
+	resp.Header.CopyTo(&ctx.Response.Header)
+	ctx.Response.SetBodyStream(&streamedBody{Reader: r, resp: resp, conn: conn, close: func() {
+		al.Sent = counted.n.Load()
+		metricHTTPBytesOut.Add(al.Sent)
+		done()
+		al.write()
+	}}, resp.Header.ContentLength())
+}
+
+// flushBody copies r to w, flushing every read so a streamed body reaches
+// an h2 client as it arrives. It returns the bytes sent.
+func flushBody(w http.ResponseWriter, r io.Reader) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	var sent int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return sent, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			sent += int64(n)
+		}
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+}
+
+// upstreamConnAt finds the upstream conn a response is read from by its local address
+func upstreamConnAt(local net.Addr) *upstreamConn {
+	if _, ok := local.(*net.TCPAddr); !ok {
+		return nil
+	}
+	upstreamConns.Lock()
+	defer upstreamConns.Unlock()
+	for c := range upstreamConns.conns {
+		if c.LocalAddr().String() == local.String() {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// chunkedOrigin returns a dial func connecting to an in-memory origin that answers with a chunked
+// "first" right away, and ends the body once release is closed
+func chunkedOrigin(t *testing.T) (func(network, address string) (net.Conn, error), chan struct{}) {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+					return
+				}
+				c.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nfirst\r\n"))
+				<-release
+				c.Write([]byte("0\r\n\r\n"))
+			}()
+		}
+	}()
+	return func(network, address string) (net.Conn, error) {
+		return ln.Dial()
+	}, release
+}
+
+func TestReverseProxyStreams(t *testing.T) {
+	dial, release := chunkedOrigin(t)
+	defer close(release)
+	ln := testProxy(t, dial)
+	backend, _ := url.Parse("http://backend.internal")
+	reverseRoutes = map[string]*url.URL{"app.example.com": backend}
+	defer func() {
+		reverseRoutes = nil
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = c.Write([]byte("GET /events HTTP/1.1\r\nHost: app.example.com\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// the first chunk arrives while the backend holds the rest
+	br := bufio.NewReader(c)
+	var head fasthttp.ResponseHeader
+	if err = head.Read(br); err != nil {
+		t.Fatal(err)
+	}
+	if head.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("status %d", head.StatusCode())
+	}
+	line, err := br.ReadString('\n')
+	if err == nil {
+		line, err = br.ReadString('\n')
+	}
+	if err != nil || line != "first\r\n" {
+		t.Errorf("first chunk: %q, %v", line, err)
+	}
+}
+
+// flushRecorder signals every Flush of a ResponseRecorder
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed chan string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed <- r.Body.String()
+}
+
+func TestFlushBody(t *testing.T) {
+	dial, release := chunkedOrigin(t)
+	client := newHTTPClient(dial)
+	defer client.CloseIdleConnections()
+	req := &fasthttp.Request{}
+	req.SetRequestURI("http://example.com/events")
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan string, 4)}
+	type result struct {
+		n   int64
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		n, err := flushBody(w, resp.BodyStream())
+		res <- result{n, err}
+	}()
+	select {
+	case got := <-w.flushed:
+		if got != "first" {
+			t.Errorf("flushed %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first chunk not flushed before the body ended")
+	}
+	close(release)
+	select {
+	case r := <-res:
+		if r.err != nil || r.n != 5 {
+			t.Errorf("sent %d, %v", r.n, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("body not done")
+	}
+}
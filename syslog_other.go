@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+func openSyslog(addr string) (severityWriter, error) {
+	return nil, errors.New("syslog is not supported on " + runtime.GOOS)
+}
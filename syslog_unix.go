@@ -0,0 +1,43 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+type syslogWriter struct {
+	*syslog.Writer
+}
+
+func (w syslogWriter) writeAt(sev severity, msg string) error {
+	switch sev {
+	case sevWarning:
+		return w.Warning(msg)
+	case sevError:
+		return w.Err(msg)
+	}
+	return w.Info(msg)
+}
+
+// openSyslog connects to the syslog at addr, udp:// or tcp://, or the local one when empty
+func openSyslog(addr string) (severityWriter, error) {
+	var network, raddr string
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid -syslog-addr: %q", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "http-proxy-server")
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// With -allow-target-header the proxy can sit behind a CDN as a fronting endpoint: clients
+// address their requests to the fronting domain and name the real destination in X-Target-Host
+// (and X-Target-Port). Whoever can send these headers picks any destination the ACLs allow,
+// as with CONNECT, and the CDN in front sees only the fronting domain in its logs. So the
+// headers are only honored for clients authenticated with -u, -auth-file or -unix-allow-uids,
+// -allow-hosts, -deny-hosts and -no-private still apply to the target, and the headers are
+// stripped before forwarding. Fronting may break the terms of service of the CDN.
+var allowTargetHeader = flag.Bool(`allow-target-header`, false, `Dial the destination named by the X-Target-Host and X-Target-Port headers of authenticated requests, for CDN fronting. Needs -u, -auth-file or -unix-allow-uids: the headers pick any destination the ACLs allow, as CONNECT does`)
+
+var errTargetHeaderAuth = errors.New("X-Target-Host needs an authenticated client")
+
+// targetHeader returns the host:port of the X-Target-Host and X-Target-Port headers of req, stripping
+// them, or "" if there are none. defaultPort applies when neither names a port.
+func targetHeader(req *fasthttp.Request, user, defaultPort string) (string, error) {
+	target := string(req.Header.Peek("X-Target-Host"))
+	targetPort := string(req.Header.Peek("X-Target-Port"))
+	req.Header.Del("X-Target-Host")
+	req.Header.Del("X-Target-Port")
+	if target == "" {
+		if targetPort != "" {
+			return "", errors.New("X-Target-Port without X-Target-Host")
+		}
+		return "", nil
+	}
+	if user == "" {
+		return "", errTargetHeaderAuth
+	}
+	hostname, port, err := splitHostPortDefault(target, defaultPort)
+	if err != nil {
+		return "", err
+	}
+	if targetPort != "" {
+		port = targetPort
+	}
+	if !validHostname(hostname) || !validPort(port) {
+		return "", errors.New("invalid X-Target-Host or X-Target-Port")
+	}
+	return net.JoinHostPort(hostname, port), nil
+}
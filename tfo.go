@@ -0,0 +1,9 @@
+package main
+
+import "flag"
+
+// TCP Fast Open sends the first bytes of a conn with its SYN, saving a round trip
+// to destinations dialed before. Only linux supports it for outbound conns here,
+// and its net.ipv4.tcp_fastopen sysctl must have bit 1 set, as it does by default.
+// The destination has to support it too, other dials fall back to a normal handshake.
+var tfo = flag.Bool(`tfo`, false, `Dial outbound connections with TCP Fast Open where supported, sending the first bytes, eg: a tunneled TLS ClientHello, with the SYN (linux only)`)
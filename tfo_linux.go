@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// TCP_FASTOPEN_CONNECT, linux 4.11+: connect returns at once and the first write goes with the SYN
+const tcpFastOpenConnect = 30
+
+var tfoUnsupported sync.Once
+
+// enableTFO sets TCP Fast Open on the sockets of d, dialing normally where the kernel refuses it
+func enableTFO(d *net.Dialer) error {
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1); err != nil {
+				tfoUnsupported.Do(func() {
+					log.Println("TCP Fast Open not supported, dialing without it:", err)
+				})
+			}
+		})
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// TCP_FASTOPEN, the server side: accept data in the SYN, with this queue length
+const tcpFastOpen = 23
+
+// benchmarkRoundTrip dials d per iteration, writes a request and reads the reply,
+// as a tunnel's first ClientHello and ServerHello would
+func benchmarkRoundTrip(b *testing.B, d *net.Dialer) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) {
+			syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, 256)
+		})
+	}}
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 64)
+				if _, err := io.ReadFull(c, buf); err == nil {
+					c.Write(buf)
+				}
+			}()
+		}
+	}()
+
+	req := make([]byte, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := d.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := c.Write(req); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(c, req); err != nil {
+			b.Fatal(err)
+		}
+		c.Close()
+	}
+}
+
+// Loopback has next to no RTT, so this shows the overhead. With a delay on lo,
+// eg: tc qdisc add dev lo root netem delay 20ms, tfo saves a round trip per dial after the first.
+func BenchmarkDial(b *testing.B) {
+	b.Run("plain", func(b *testing.B) {
+		benchmarkRoundTrip(b, &net.Dialer{})
+	})
+	b.Run("tfo", func(b *testing.B) {
+		d := &net.Dialer{}
+		if err := enableTFO(d); err != nil {
+			b.Skip(err)
+		}
+		benchmarkRoundTrip(b, d)
+	})
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+func enableTFO(d *net.Dialer) error {
+	return errors.New("TCP Fast Open is not supported on " + runtime.GOOS)
+}
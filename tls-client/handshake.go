@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const (
+	socks5Version    = 0x05
+	socks5AuthNone   = 0x00
+	socks5CmdConnect = 0x01
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+)
+
+// connectHandshake reads an HTTP CONNECT request and answers it, returning the target addr
+func connectHandshake(c net.Conn, br *bufio.Reader) (string, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return "", err
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\nAllow: CONNECT\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return "", fmt.Errorf("method not allowed: %s", req.Method)
+	}
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		io.WriteString(c, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		return "", err
+	}
+	_, err = io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return req.Host, err
+}
+
+// socks5Handshake negotiates no auth and reads a CONNECT request, returning the target addr
+func socks5Handshake(c net.Conn, br *bufio.Reader) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return "", err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", err
+	}
+	method := byte(0xff)
+	for _, m := range methods {
+		if m == socks5AuthNone {
+			method = socks5AuthNone
+		}
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return "", err
+	}
+	if method != socks5AuthNone {
+		return "", errors.New("no acceptable auth method")
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return "", err
+	}
+	if req[1] != socks5CmdConnect {
+		socks5Reply(c, 0x07)
+		return "", fmt.Errorf("unsupported command: %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if req[3] == socks5AtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(br, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socks5Reply(c, 0x08)
+		return "", fmt.Errorf("unsupported address type: %d", req[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return "", err
+	}
+	// the bound address is on the tls-server side, report none
+	if err := socks5Reply(c, 0x00); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func socks5Reply(c net.Conn, rep byte) error {
+	_, err := c.Write([]byte{socks5Version, rep, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var listen = flag.String(`l`, `127.0.0.1:1080`, `Local HTTP CONNECT and SOCKS5 proxy listen address. Eg: :1080; unix:/tmp/proxy.sock`)
+var server = flag.String(`server`, ``, `tls-server address. Eg: example.com:443`)
+var creds = flag.String(`creds`, ``, `tls-server credentials (token)`)
+var sni = flag.String(`sni`, ``, `tls-server sni (default: host of -server)`)
+var certFile = flag.String(`cert`, ``, `Client certificate file for tls-server -client-ca. Eg: client.pem`)
+var keyFile = flag.String(`key`, ``, `Client private key file. Eg: client.key`)
+var insecure = flag.Bool(`insecure`, false, `Don't verify the tls-server certificate (default: verify against system roots)`)
+
+var dialTimeout = 7 * time.Second
+
+var zeroTime = time.Time{}
+
+var tlsDialer *tls.Dialer
+
+// dialServer opens a tunnel to addr through the tls-server, sending initial along with the addr
+func dialServer(addr string, initial []byte) (net.Conn, error) {
+	if strings.ContainsAny(addr, "\n") {
+		return nil, errors.New("invalid addr")
+	}
+	c, err := tlsDialer.Dial("tcp", *server)
+	if err != nil {
+		return nil, err
+	}
+	var d []byte
+	d = append(d, *creds...)
+	d = append(d, addr...)
+	d = append(d, '\n')
+	d = append(d, initial...)
+	c.SetWriteDeadline(time.Now().Add(dialTimeout))
+	if _, err = c.Write(d); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetWriteDeadline(zeroTime)
+	return c, nil
+}
+
+func serve(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(dialTimeout))
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	var addr string
+	if first[0] == socks5Version {
+		addr, err = socks5Handshake(c, br)
+	} else {
+		addr, err = connectHandshake(c, br)
+	}
+	if err != nil {
+		log.Println("handshake:", c.RemoteAddr().String(), err)
+		return
+	}
+	c.SetReadDeadline(zeroTime)
+
+	// bytes the client sent ahead of the handshake reply
+	initial, _ := br.Peek(br.Buffered())
+	r, err := dialServer(addr, initial)
+	if err != nil {
+		log.Println("server:", addr, err)
+		return
+	}
+	defer r.Close()
+	log.Println("Connect:", addr, c.RemoteAddr().String())
+	relay(c, r, addr)
+}
+
+// relay splices c and r, passing EOF on as a half-close, until both directions finish
+func relay(c, r net.Conn, addr string) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		if _, err := io.Copy(dst, src); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("relay:", addr, err)
+			}
+			dst.Close()
+			src.Close()
+			return
+		}
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(r, c)
+	go copyHalf(c, r)
+	wg.Wait()
+}
+
+func main() {
+	flag.Parse()
+	if *server == "" {
+		log.Panicln("Not found args: -server")
+	}
+	serverName := *sni
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(*server)
+	}
+	config := &tls.Config{ServerName: serverName}
+	if err := configureVerify(config); err != nil {
+		log.Panicln(err)
+	}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Panicln(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	tlsDialer = &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: dialTimeout},
+		Config:    config,
+	}
+
+	var ln net.Listener
+	var err error
+	if strings.HasPrefix(*listen, `unix:`) {
+		unixFile := (*listen)[5:]
+		os.Remove(unixFile)
+		ln, err = net.Listen(`unix`, unixFile)
+		os.Chmod(unixFile, os.ModePerm)
+	} else {
+		ln, err = net.Listen(`tcp`, *listen)
+	}
+	if err != nil {
+		log.Panicln(err)
+	}
+	log.Println(`Listening:`, ln.Addr().String())
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println("accept:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go serve(c)
+	}
+}
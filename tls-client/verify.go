@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var serverCA = flag.String(`server-ca`, ``, `Verify the tls-server certificate against this CA file instead of system roots. Eg: ca.pem`)
+var serverPin = flag.String(`server-pin`, ``, `Accept only tls-server certificates with these comma separated SHA-256 hex fingerprints, instead of verifying the chain. Eg: openssl x509 -in cert.pem -outform der | sha256sum`)
+
+// configureVerify applies -server-ca, -server-pin and -insecure to config
+func configureVerify(config *tls.Config) error {
+	if *serverCA != "" {
+		pem, err := os.ReadFile(*serverCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", *serverCA)
+		}
+		config.RootCAs = pool
+	}
+	if *serverPin != "" {
+		var pins [][]byte
+		for _, p := range strings.Split(*serverPin, ",") {
+			p = strings.ReplaceAll(strings.TrimSpace(p), ":", "")
+			pin, err := hex.DecodeString(p)
+			if err != nil || len(pin) != sha256.Size {
+				return fmt.Errorf("invalid pin: %q", p)
+			}
+			pins = append(pins, pin)
+		}
+		// the pin replaces chain verification, so self-signed certs can be pinned
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				sum := sha256.Sum256(raw)
+				for _, pin := range pins {
+					if bytes.Equal(sum[:], pin) {
+						return nil
+					}
+				}
+			}
+			return errors.New("tls-server certificate doesn't match -server-pin")
+		}
+		return nil
+	}
+	config.InsecureSkipVerify = *insecure
+	return nil
+}
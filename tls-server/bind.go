@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var bindIPs = flag.String(`bind-ips`, ``, `Local source IPs for outbound dials, picked round-robin with optional weight. Eg: 10.0.0.1,10.0.0.2@2`)
+
+// how long a bind ip that failed to bind is skipped
+var bindIPDownDuration = time.Minute
+
+type bindAddr struct {
+	ip        net.IP
+	dialer    *net.Dialer
+	udpDialer *net.Dialer
+	downUntil atomic.Int64
+}
+
+// bindDialer round-robins outbound dials across local source addresses
+type bindDialer struct {
+	addrs []*bindAddr
+	next  atomic.Uint32
+}
+
+func newBindDialer(spec string) (*bindDialer, error) {
+	b := &bindDialer{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		ipStr, weightStr, hasWeight := strings.Cut(entry, "@")
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid bind ip: %q", entry)
+		}
+		weight := 1
+		if hasWeight {
+			var err error
+			weight, err = strconv.Atoi(weightStr)
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid bind ip weight: %q", entry)
+			}
+		}
+
+		// make sure the address belongs to this host
+		if ln, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), "0")); err != nil {
+			log.Println("Skip unusable bind ip:", ip, err)
+			continue
+		} else {
+			ln.Close()
+		}
+
+		a := &bindAddr{
+			ip: ip,
+			dialer: &net.Dialer{
+				Timeout:   dialTimeout,
+				KeepAlive: dialKeepalive(),
+				LocalAddr: &net.TCPAddr{IP: ip},
+			},
+			udpDialer: &net.Dialer{
+				Timeout:   dialTimeout,
+				LocalAddr: &net.UDPAddr{IP: ip},
+			},
+		}
+		for i := 0; i < weight; i++ {
+			b.addrs = append(b.addrs, a)
+		}
+	}
+	if len(b.addrs) == 0 {
+		return nil, errors.New("no usable bind ip")
+	}
+	return b, nil
+}
+
+func (b *bindDialer) Dial(network, address string) (net.Conn, error) {
+	n := uint32(len(b.addrs))
+	start := b.next.Add(1)
+	var lastErr error
+	for i := uint32(0); i < n; i++ {
+		a := b.addrs[(start+i)%n]
+		if a.downUntil.Load() > time.Now().UnixNano() {
+			continue
+		}
+		d := a.dialer
+		if strings.HasPrefix(network, "udp") {
+			d = a.udpDialer
+		}
+		c, err := d.Dial(network, address)
+		if err == nil {
+			return c, nil
+		}
+		lastErr = err
+
+		var addrErr *net.AddrError
+		if errors.As(err, &addrErr) {
+			// address family of the bind ip doesn't match the destination
+			continue
+		}
+		if errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE) {
+			log.Println("bind ip unusable:", a.ip, err)
+			a.downUntil.Store(time.Now().Add(bindIPDownDuration).UnixNano())
+			continue
+		}
+		return nil, err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("no usable bind ip for " + address)
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"os"
+	"sort"
+	"strings"
+)
+
+var credsFile = flag.String(`creds-file`, ``, `File of accepted tokens, one per line, in addition to -u`)
+
+type token struct {
+	b     []byte
+	label string
+}
+
+// tokens are sorted longest first, so a token that is a prefix of another doesn't shadow it
+var tokens []token
+
+// tokenLabel identifies a token in logs without revealing it
+func tokenLabel(t string) string {
+	if len(t) <= 8 {
+		return strings.Repeat("*", len(t))
+	}
+	return t[:4] + "..."
+}
+
+func addToken(t string) {
+	tokens = append(tokens, token{b: []byte(t), label: tokenLabel(t)})
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return len(tokens[i].b) > len(tokens[j].b)
+	})
+}
+
+func loadCredsFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		addToken(line)
+	}
+	return scanner.Err()
+}
+
+// matchToken splits a token+addr line into the token and addr
+func matchToken(line []byte) (*token, []byte) {
+	for i := range tokens {
+		if bytes.HasPrefix(line, tokens[i].b) {
+			return &tokens[i], line[len(tokens[i].b):]
+		}
+	}
+	return nil, nil
+}
+
+// maxTokenLen returns the length of the longest token
+func maxTokenLen() int {
+	if len(tokens) == 0 {
+		return 0
+	}
+	return len(tokens[0].b)
+}
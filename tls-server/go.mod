@@ -1,3 +1,13 @@
 module tls-server
 
 go 1.20
+
+require shared v0.0.0
+
+require (
+	golang.org/x/crypto v0.7.0 // indirect
+	golang.org/x/net v0.8.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
+)
+
+replace shared => ../shared
@@ -1,35 +1,45 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"crypto/tls"
 	"flag"
 	"io"
 	"log"
 	"net"
-	"os"
 	"strings"
 	"sync"
 	"time"
+
+	"shared"
 )
 
-var listen = flag.String(`l`, `:443`, `Listen address. Eg: :8443; unix:/tmp/proxy.sock`)
+var listen = flag.String(`l`, `:443`, `Listen address, unused when socket activated by systemd. Eg: :8443; unix:/tmp/proxy.sock`)
 var certFile = flag.String(`cert`, ``, `Certificate file (for tls). Eg: cert.pem`)
 var keyFile = flag.String(`key`, ``, `Private key file (for tls). Eg: cert.key`)
-var creds = flag.String(`u`, ``, `Credentials (token)`)
-var credsLen int
-var credsByte []byte
+var checkOnly = flag.Bool(`check`, false, `Validate flags, certificates, tokens and the listen address, then exit without serving`)
+var creds = flag.String(`u`, ``, `Credentials (token). Kept out of ps when given in the file named by $PROXY_TOKEN_FILE or in $PROXY_TOKEN instead`)
 
 var dialTimeout = 7 * time.Second
 
 var localDialFunc = (&net.Dialer{
 	Timeout:   dialTimeout,
 	DualStack: true,
+	KeepAlive: dialKeepalive(),
 }).Dial
 
 var TCPKeepalive bool = true
 var TCPKeepalivePeriod time.Duration
 
+// dialKeepalive is the net.Dialer KeepAlive of outbound dials, the same as of accepted conns
+func dialKeepalive() time.Duration {
+	if !TCPKeepalive {
+		return -1
+	}
+	// 0: go's default period
+	return TCPKeepalivePeriod
+}
+
 func acceptConn(ln net.Listener) (net.Conn, error) {
 	for {
 		c, err := ln.Accept()
@@ -64,10 +74,9 @@ func acceptConn(ln net.Listener) (net.Conn, error) {
 }
 
 var bufLen int
-var bytePool = &sync.Pool{
+var readerPool = &sync.Pool{
 	New: func() any {
-		b := make([]byte, bufLen)
-		return &b
+		return bufio.NewReaderSize(nil, bufLen)
 	},
 }
 
@@ -75,113 +84,172 @@ var zeroTime = time.Time{}
 
 func serve(c net.Conn) {
 	defer c.Close()
-	var buf = (*bytePool.Get().(*[]byte))[:]
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(c)
+	release := func() {
+		br.Reset(nil)
+		readerPool.Put(br)
+	}
+
+	// token, addr and \n may arrive split over any number of reads
 	c.SetReadDeadline(time.Now().Add(dialTimeout))
-	n, err := c.Read(buf)
+	line, err := br.ReadSlice('\n')
 	if err != nil {
-		bytePool.Put(&buf)
-		log.Println("read:", c.RemoteAddr().String(), err)
-		return
-	}
-	if n < credsLen {
-		log.Println(string(buf))
-		bytePool.Put(&buf)
-		log.Println("read n < credsLen", c.RemoteAddr().String())
+		release()
+		log.Println("read addr:", c.RemoteAddr().String(), err)
 		return
 	}
-
 	c.SetReadDeadline(zeroTime)
-
-	addr := buf[:n]
-	idx := bytes.IndexRune(addr, '\n')
-	if idx == -1 {
-		bytePool.Put(&buf)
-		log.Println("read not found \\n", c.RemoteAddr().String())
+	t, addrBytes := matchToken(line[:len(line)-1])
+	if t == nil {
+		release()
+		log.Println("auth failed", c.RemoteAddr().String())
 		return
 	}
-	if idx <= credsLen {
-		log.Println(string(buf))
-		bytePool.Put(&buf)
-		log.Println("read idx < bufLen", c.RemoteAddr().String())
-		return
+	addr := string(addrBytes)
+	label := t.label
+	if tc, ok := c.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		if id := shared.CertIdentity(&cs); id != "" {
+			label += " cert:" + id
+		}
 	}
+	log.Println("Connect:", label, addr, c.RemoteAddr().String())
 
-	authStr := addr[:credsLen]
-	if !bytes.Equal(authStr, credsByte) {
-		bytePool.Put(&buf)
-		log.Println("auth failed", c.RemoteAddr().String())
+	if udpAddr, ok := strings.CutPrefix(addr, udpPrefix); ok {
+		u, err := localDialFunc("udp", udpAddr)
+		if err != nil {
+			release()
+			log.Println("remote udp failed", c.RemoteAddr().String(), err)
+			return
+		}
+		// frames already read stay in br
+		relayUDP(c, br, u, udpAddr)
+		release()
 		return
 	}
-	rest := addr[idx+1:]
-	addr = addr[credsLen:idx]
 
-	// log.Println(string(authStr), string(addr), string(rest))
-
-	r, err := localDialFunc("tcp", string(addr))
+	r, err := localDialFunc("tcp", addr)
 	if err != nil {
-		bytePool.Put(&buf)
+		release()
 		log.Println("remote connect failed", c.RemoteAddr().String())
 		return
 	}
 	defer r.Close()
 
-	if len(rest) != 0 {
+	// payload read along with the addr
+	if n := br.Buffered(); n != 0 {
+		rest, _ := br.Peek(n)
 		r.SetWriteDeadline(time.Now().Add(dialTimeout))
 		_, err = r.Write(rest)
 		if err != nil {
-			bytePool.Put(&buf)
+			release()
 			log.Println("remote write failed:", c.RemoteAddr().String(), err)
 			return
 		}
 		r.SetWriteDeadline(zeroTime)
 	}
 
-	bytePool.Put(&buf)
+	release()
+	relay(c, r, addr)
+}
 
-	go io.Copy(r, c)
-	io.Copy(c, r)
+// checked logs a validated part of the setup under -check
+func checked(v ...any) {
+	if *checkOnly {
+		log.Println(append([]any{"Check ok:"}, v...)...)
+	}
 }
 
 func main() {
 	flag.Parse()
-	if *certFile == "" || *keyFile == "" {
-		log.Panicln("Not found args: -certFile, -keyFile")
+	if *shared.ACMEDomains == "" && (*certFile == "" || *keyFile == "") && *shared.CertDir == "" {
+		log.Panicln("Not found args: -certFile, -keyFile, -cert-dir or -acme-domains")
 		return
 	}
-	credsLen = len(*creds)
-	credsByte = []byte(*creds)
-	bufLen = credsLen /*auth str*/ + 253 /*domain*/ + 2 /* 2 brackes [] */ + 1 /* : */ + 5 /*port*/ + 1 /*\n*/
-
-	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
-	if err != nil {
-		log.Panicln(err)
-		return
+	if *bindIPs != "" {
+		b, err := newBindDialer(*bindIPs)
+		if err != nil {
+			log.Panicln(err)
+		}
+		localDialFunc = b.Dial
 	}
 
-	tlsConfig := &tls.Config{}
-	tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	if *creds == "" {
+		var err error
+		if *creds, err = shared.CredsFromEnv("PROXY_TOKEN_FILE", "PROXY_TOKEN"); err != nil {
+			log.Panicln(err)
+		}
+	}
+	if *creds != "" || *credsFile == "" {
+		addToken(*creds)
+	}
+	if *credsFile != "" {
+		if err := loadCredsFile(*credsFile); err != nil {
+			log.Panicln(err)
+		}
+		log.Println("Loaded tokens:", len(tokens))
+	}
+	checked("tokens")
+	if *relayBuffer < 512 {
+		log.Panicln("-relay-buffer must be at least 512")
+	}
+	bufLen = maxTokenLen() /*auth str*/ + len(udpPrefix) + 253 /*domain*/ + 2 /* 2 brackes [] */ + 1 /* : */ + 5 /*port*/ + 1 /*\n*/
 
-	// BuildNameToCertificate has been deprecated since 1.14.
-	// But since we also support older versions we'll keep this here.
-	tlsConfig.BuildNameToCertificate() //nolint:staticcheck
+	var tlsConfig *tls.Config
+	if *shared.ACMEDomains != "" {
+		tlsConfig = shared.ACMETLSConfig()
+	} else {
+		certs, err := shared.NewCertHolder(*certFile, *keyFile, *shared.CertDir)
+		if err != nil {
+			log.Panicln(err)
+			return
+		}
+		if !*checkOnly {
+			go certs.Watch(*shared.CertWatch)
+		}
+		tlsConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	}
+	if err := shared.ApplyTLSOptions(tlsConfig); err != nil {
+		log.Panicln(err)
+	}
+	if *shared.ClientCA != "" {
+		if err := shared.RequireClientCerts(tlsConfig); err != nil {
+			log.Panicln(err)
+		}
+	}
+	checked("tls")
 
 	// Server
 	var ln net.Listener
-	if strings.HasPrefix(*listen, `unix:`) {
+	activated, err := shared.SystemdListeners()
+	if err != nil {
+		log.Panicln(err)
+	}
+	if len(activated) != 0 {
+		ln = activated[0]
+		for _, l := range activated[1:] {
+			log.Println(`Ignoring extra systemd socket:`, l.Addr().String())
+			l.Close()
+		}
+		log.Println(`Listening (systemd):`, ln.Addr().String())
+	} else if strings.HasPrefix(*listen, `unix:`) {
 		unixFile := (*listen)[5:]
-		os.Remove(unixFile)
-		ln, err = net.Listen(`unix`, unixFile)
-		os.Chmod(unixFile, os.ModePerm)
+		if ln, err = shared.ListenUnix(unixFile, *checkOnly); err != nil {
+			log.Panicln(err)
+		}
 		log.Println(`Listening:`, unixFile)
 	} else {
-		ln, err = net.Listen(`tcp`, *listen)
+		if ln, err = net.Listen(`tcp`, *listen); err != nil {
+			log.Panicln(err)
+		}
 		log.Println(`Listening:`, ln.Addr().String())
 	}
-	if err != nil {
-		log.Panicln(err)
-	}
-	if ln == nil {
-		log.Panicln(`Error listening:`, *listen)
+	if *checkOnly {
+		ln.Close()
+		checked("listen", *listen)
+		log.Println("Check passed")
+		return
 	}
 
 	tlsLn := tls.NewListener(ln, tlsConfig.Clone())
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var idleTimeout = flag.Duration(`idle-timeout`, 5*time.Minute, `Close tunnels with no traffic in either direction for this long (0: never)`)
+var relayBuffer = flag.Int(`relay-buffer`, 32*1024, `Size of the pooled buffer of each relay direction`)
+
+var relayBufPool = &sync.Pool{
+	New: func() any {
+		b := make([]byte, *relayBuffer)
+		return &b
+	},
+}
+
+// activeReader records the time of its last successful read
+type activeReader struct {
+	r          io.Reader
+	lastActive *atomic.Int64
+}
+
+func (a *activeReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// relay splices c and r, passing EOF on as a half-close, until both directions finish
+func relay(c, r net.Conn, addr string) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	if *idleTimeout > 0 {
+		go watchIdle(&lastActive, *idleTimeout, done, addr, c, r)
+	}
+
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		buf := relayBufPool.Get().(*[]byte)
+		defer relayBufPool.Put(buf)
+		// hide ReadFrom, which would copy through its own buffer instead
+		w := struct{ io.Writer }{dst}
+		if _, err := io.CopyBuffer(w, &activeReader{r: src, lastActive: &lastActive}, *buf); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("relay:", addr, err)
+			}
+			// unblock the other direction
+			dst.Close()
+			src.Close()
+			return
+		}
+		closeWrite(dst)
+	}
+	wg.Add(2)
+	go copyHalf(r, c)
+	go copyHalf(c, r)
+	wg.Wait()
+	close(done)
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes c if supported, otherwise closes it
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(closeWriter); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// watchIdle closes conns once lastActive is older than timeout
+func watchIdle(lastActive *atomic.Int64, timeout time.Duration, done <-chan struct{}, addr string, conns ...net.Conn) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			idle := time.Since(time.Unix(0, lastActive.Load()))
+			if idle >= timeout {
+				log.Println("idle timeout:", addr)
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			t.Reset(timeout - idle)
+		}
+	}
+}
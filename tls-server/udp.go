@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// UDP relay framing. The request line names the target with a udp/ prefix:
+//
+//	token + "udp/" + host:port + "\n"
+//
+// after which each datagram travels over the TLS stream, in both directions, as
+//
+//	2 byte big-endian payload length + payload
+//
+// The server sends from one UDP socket connected to host:port, so only
+// datagrams from host:port are relayed back. Closing the stream ends the relay.
+const udpPrefix = "udp/"
+
+const maxDatagram = 65535
+
+// relayUDP relays framed datagrams read from br to u, and datagrams from u to c
+func relayUDP(c net.Conn, br *bufio.Reader, u net.Conn, addr string) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	done := make(chan struct{})
+	if *idleTimeout > 0 {
+		go watchIdle(&lastActive, *idleTimeout, done, addr, c, u)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer u.Close()
+		buf := make([]byte, maxDatagram)
+		var head [2]byte
+		for {
+			if _, err := io.ReadFull(br, head[:]); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint16(head[:])
+			if _, err := io.ReadFull(br, buf[:n]); err != nil {
+				return
+			}
+			lastActive.Store(time.Now().UnixNano())
+			if _, err := u.Write(buf[:n]); err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+				log.Println("udp write:", addr, err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer c.Close()
+		buf := make([]byte, 2+maxDatagram)
+		for {
+			n, err := u.Read(buf[2:])
+			if err != nil {
+				// ICMP port unreachable for an earlier datagram
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					continue
+				}
+				return
+			}
+			binary.BigEndian.PutUint16(buf, uint16(n))
+			lastActive.Store(time.Now().UnixNano())
+			if _, err := c.Write(buf[:2+n]); err != nil {
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+}
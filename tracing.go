@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var otelEndpoint = flag.String(`otel-endpoint`, ``, `Export a trace span per request and tunnel to this OTLP/HTTP collector. Eg: http://127.0.0.1:4318 (default: no tracing)`)
+var otelServiceName = flag.String(`otel-service-name`, `http-proxy-server`, `service.name of exported spans`)
+
+// spans exported per request, older ones are dropped when the collector falls behind
+const (
+	maxQueuedSpans = 2048
+	maxSpanBatch   = 512
+)
+
+type spanEvent struct {
+	TimeUnixNano string `json:"timeUnixNano"`
+	Name         string `json:"name"`
+}
+
+type spanAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []spanAttr     `json:"attributes"`
+	Events       []spanEvent    `json:"events,omitempty"`
+	Status       map[string]int `json:"status"`
+}
+
+var spanQueue struct {
+	sync.Mutex
+	spans []otlpSpan
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceRequest starts the span of l, continuing the client's trace when traceparent is valid.
+// It returns the traceparent to forward to the origin, empty when not tracing.
+func traceRequest(l *accessLog, traceparent string) string {
+	if *otelEndpoint == "" {
+		return ""
+	}
+	l.spanID = randomHex(8)
+	// version-traceid-parentid-flags, eg: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	if f := strings.Split(traceparent, "-"); len(f) == 4 && len(f[1]) == 32 && len(f[2]) == 16 &&
+		strings.Trim(f[1], "0") != "" && strings.Trim(f[2], "0") != "" {
+		if _, err := hex.DecodeString(f[1] + f[2]); err == nil {
+			l.traceID, l.parentSpanID = strings.ToLower(f[1]), strings.ToLower(f[2])
+		}
+	}
+	if l.traceID == "" {
+		l.traceID = randomHex(16)
+	}
+	return "00-" + l.traceID + "-" + l.spanID + "-01"
+}
+
+// event adds a timestamped event to the span of l, if tracing
+func (l *accessLog) event(name string) {
+	if l.spanID != "" {
+		l.events = append(l.events, spanEvent{TimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10), Name: name})
+	}
+}
+
+func strAttr(k, v string) spanAttr {
+	return spanAttr{Key: k, Value: map[string]any{"stringValue": v}}
+}
+
+func intAttr(k string, v int64) spanAttr {
+	return spanAttr{Key: k, Value: map[string]any{"intValue": strconv.FormatInt(v, 10)}}
+}
+
+// queueSpan ends the span of l and queues it for export
+func queueSpan(l *accessLog) {
+	end := time.Now()
+	s := otlpSpan{
+		TraceID:      l.traceID,
+		SpanID:       l.spanID,
+		ParentSpanID: l.parentSpanID,
+		Name:         l.Method,
+		Kind:         2, // server
+		Start:        strconv.FormatInt(l.start.UnixNano(), 10),
+		End:          strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: []spanAttr{
+			strAttr("http.request.method", l.Method),
+			strAttr("server.address", l.Host),
+			strAttr("client.address", l.Remote),
+			strAttr("proxy.request_id", l.ID),
+			intAttr("http.response.status_code", int64(l.Status)),
+			intAttr("proxy.bytes_sent", l.Sent),
+			intAttr("proxy.bytes_received", l.Received),
+			intAttr("proxy.duration_ms", end.Sub(l.start).Milliseconds()),
+		},
+		Events: l.events,
+		Status: map[string]int{"code": 0},
+	}
+	if l.Status >= 500 {
+		s.Status["code"] = 2 // error
+	}
+
+	spanQueue.Lock()
+	if len(spanQueue.spans) >= maxQueuedSpans {
+		spanQueue.spans = spanQueue.spans[1:]
+	}
+	spanQueue.spans = append(spanQueue.spans, s)
+	spanQueue.Unlock()
+}
+
+// exportSpans sends the queued spans to -otel-endpoint every interval
+func exportSpans(interval time.Duration) {
+	for range time.Tick(interval) {
+		flushSpans()
+	}
+}
+
+var exportMu sync.Mutex
+
+// flushSpans sends all queued spans, in batches of maxSpanBatch
+func flushSpans() {
+	exportMu.Lock()
+	defer exportMu.Unlock()
+	for {
+		spanQueue.Lock()
+		n := len(spanQueue.spans)
+		if n > maxSpanBatch {
+			n = maxSpanBatch
+		}
+		batch := spanQueue.spans[:n:n]
+		spanQueue.spans = spanQueue.spans[n:]
+		spanQueue.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := postSpans(batch); err != nil {
+			log.Println("Export spans:", err)
+			return
+		}
+	}
+}
+
+var otlpClient = &http.Client{Timeout: 10 * time.Second}
+
+func postSpans(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": []spanAttr{strAttr("service.name", *otelServiceName)}},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "http-proxy-server"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := otlpClient.Post(strings.TrimSuffix(*otelEndpoint, "/")+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", *otelEndpoint, resp.Status)
+	}
+	return nil
+}
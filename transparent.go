@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+var transparent = flag.Bool(`transparent`, false, `Forward origin-form HTTP requests to their original destination, for use behind iptables REDIRECT (Linux only). Such requests skip proxy auth`)
+
+// transparentTarget returns the original destination of an origin-form request,
+// one a client sent not knowing it is proxied
+func transparentTarget(ctx *fasthttp.RequestCtx) (string, bool, error) {
+	if !*transparent || ctx.IsConnect() {
+		return "", false, nil
+	}
+	if uri := ctx.Request.Header.RequestURI(); len(uri) == 0 || uri[0] != '/' {
+		return "", false, nil
+	}
+	c := ctx.Conn()
+	for {
+		t, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = t.NetConn()
+	}
+	dst, err := originalDst(c)
+	return dst, true, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var tunnelIdleTimeout = flag.Duration(`tunnel-idle-timeout`, 0, `Close CONNECT tunnels with no traffic in either direction for this long (default: -idle-timeout)`)
+var maxTunnelDuration = flag.Duration(`max-tunnel-duration`, 0, `Close CONNECT and SOCKS5 tunnels open this long, busy or not (0: unlimited)`)
+
+// relay splices clientConn and r until the tunnel to remoteAddr closes
+func relay(clientConn, r net.Conn, remoteAddr string, al *accessLog) {
+	metricActiveTunnels.Add(1)
+	defer metricActiveTunnels.Add(-1)
+
+	// closing a fasthttp hijacked conn is a no-op, close the underlying one
+	clientRaw := clientConn
+	if u, ok := clientConn.(interface{ UnsafeConn() net.Conn }); ok {
+		clientRaw = u.UnsafeConn()
+	}
+
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	up := &countReader{r: limitReader(clientConn, al.User), total: &tunnelBytesUp, active: &lastActive}
+	down := &countReader{r: limitReader(r, al.User), total: &tunnelBytesDown, active: &lastActive}
+	defer trackTunnel(&openTunnel{client: clientRaw, remote: r, dest: remoteAddr, al: al, up: up, down: down})()
+
+	// half-open tunnels are ended by the idle watcher
+	done := make(chan struct{})
+	if idle := time.Duration(liveTunnelIdleTimeout.Load()); idle > 0 {
+		go watchIdle(&lastActive, idle, done, al, remoteAddr, clientRaw, r)
+	}
+	if d := *maxTunnelDuration; d > 0 {
+		// closing both conns unblocks both copies
+		timer := time.AfterFunc(d, func() {
+			al.logln("Tunnel max duration reached:", remoteAddr, "up:", up.n.Load(), "down:", down.n.Load())
+			clientRaw.Close()
+			r.Close()
+		})
+		defer timer.Stop()
+	}
+
+	var wg sync.WaitGroup
+	copyHalf := func(dst io.Writer, src io.Reader, dstConn, srcConn net.Conn, dir string) {
+		defer wg.Done()
+		if _, err := io.Copy(dst, src); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				al.logln("tunnel "+dir+":", remoteAddr, err, "up:", up.n.Load(), "down:", down.n.Load())
+			}
+			// unblock the other direction
+			dstConn.Close()
+			srcConn.Close()
+			return
+		}
+		// pass EOF on and let the other direction finish
+		closeWrite(dstConn)
+	}
+	wg.Add(2)
+	go copyHalf(r, up, r, clientRaw, "up")
+	go copyHalf(clientConn, down, clientRaw, r, "down")
+	wg.Wait()
+	close(done)
+	clientRaw.Close()
+	r.Close()
+
+	al.logln("Tunnel closed:", remoteAddr, "up:", up.n.Load(), "down:", down.n.Load())
+	if al.Status == 0 {
+		al.Status = fasthttp.StatusOK
+	}
+	al.Sent = down.n.Load()
+	al.Received = up.n.Load()
+	al.write()
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWrite half-closes c if supported, otherwise closes it
+func closeWrite(c net.Conn) {
+	if cw, ok := c.(closeWriter); ok {
+		cw.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// watchIdle closes conns once lastActive is older than timeout
+func watchIdle(lastActive *atomic.Int64, timeout time.Duration, done <-chan struct{}, al *accessLog, remoteAddr string, conns ...net.Conn) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			idle := time.Since(time.Unix(0, lastActive.Load()))
+			if idle >= timeout {
+				al.logln("Tunnel idle timeout:", remoteAddr)
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			t.Reset(timeout - idle)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"flag"
+	"html/template"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var uiListen = flag.String(`ui-listen`, ``, `Serve an HTML page of the open tunnels and totals on its own listener, a bare :port binds to localhost. Eg: :9103`)
+var uiAuth = flag.String(`ui-auth`, ``, `Basic auth credentials required by -ui-listen. Eg: user:pass`)
+
+// seconds between reloads of the page
+const uiRefresh = 2
+
+type uiTunnel struct {
+	ID, Remote, User, Dest string
+	Age                    time.Duration
+	Up, Down               int64
+	Intercepted            bool
+}
+
+type uiPage struct {
+	Refresh int
+	Stats   adminStats
+	Tunnels []uiTunnel
+}
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta http-equiv="refresh" content="{{.Refresh}}"><title>http-proxy-server</title>
+<style>body{font:14px monospace;margin:1em}table{border-collapse:collapse}td,th{padding:2px 10px;text-align:left;border-bottom:1px solid #ddd}</style>
+</head><body>
+<p>uptime {{printf "%.0f" .Stats.Uptime}}s, connections {{.Stats.Connections}}, requests {{.Stats.Requests}},
+tunnels {{.Stats.ActiveTunnels}}, tunnel sent {{.Stats.TunnelSent}} B, received {{.Stats.TunnelReceived}} B</p>
+<table><tr><th>id</th><th>client</th><th>user</th><th>destination</th><th>age</th><th>up</th><th>down</th></tr>
+{{range .Tunnels}}<tr><td>{{.ID}}</td><td>{{.Remote}}</td><td>{{.User}}</td><td>{{.Dest}}</td><td>{{.Age}}</td>
+{{if .Intercepted}}<td colspan="2">intercepted</td>{{else}}<td>{{.Up}}</td><td>{{.Down}}</td>{{end}}</tr>
+{{end}}</table></body></html>
+`))
+
+// snapshotTunnels returns the open tunnels, oldest first
+func snapshotTunnels() []uiTunnel {
+	now := time.Now()
+	openTunnels.Lock()
+	tunnels := make([]uiTunnel, 0, len(openTunnels.m))
+	for t := range openTunnels.m {
+		u := uiTunnel{
+			ID:          t.al.ID,
+			Remote:      t.al.Remote,
+			User:        t.al.User,
+			Dest:        t.dest,
+			Age:         now.Sub(t.al.start).Truncate(time.Second),
+			Intercepted: t.up == nil,
+		}
+		if t.up != nil {
+			u.Up, u.Down = t.up.n.Load(), t.down.n.Load()
+		}
+		tunnels = append(tunnels, u)
+	}
+	openTunnels.Unlock()
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].Age > tunnels[j].Age
+	})
+	return tunnels
+}
+
+// serveUI serves the tunnels page on its own listener, never through the proxy
+func serveUI(addr string, servers []*fasthttp.Server) {
+	addr = adminAddr(addr)
+	want := []byte(*uiAuth)
+	log.Println(`UI listening:`, addr)
+	log.Panicln(fasthttp.ListenAndServe(addr, func(ctx *fasthttp.RequestCtx) {
+		var cred []byte
+		if encoded, ok := bytes.CutPrefix(ctx.Request.Header.Peek("Authorization"), []byte("Basic ")); ok {
+			cred, _ = base64.StdEncoding.DecodeString(string(encoded))
+		}
+		if subtle.ConstantTimeCompare(cred, want) != 1 {
+			ctx.Response.Header.Set("WWW-Authenticate", `Basic realm="http-proxy-server"`)
+			ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+		if string(ctx.Path()) != "/" {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			return
+		}
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.Response.Header.Set("Cache-Control", "no-store")
+		page := uiPage{Refresh: uiRefresh, Stats: snapshotStats(servers), Tunnels: snapshotTunnels()}
+		if err := uiTemplate.Execute(ctx, page); err != nil {
+			log.Println("ui:", err)
+		}
+	}))
+}
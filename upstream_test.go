@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// testHTTPProxy listens on loopback and answers every request with "ok",
+// the requests it receives are sent on the returned channel
+func testHTTPProxy(t *testing.T) (string, <-chan *http.Request) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	requests := make(chan *http.Request, 16)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil {
+						return
+					}
+					requests <- req
+					c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), requests
+}
+
+func TestForwardToHTTPProxy(t *testing.T) {
+	addr, requests := testHTTPProxy(t)
+	client := newHTTPClient(noDial(t))
+	if err := forwardToHTTPProxy(client, "http://user:pass@"+addr); err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseIdleConnections()
+
+	tests := []struct {
+		uri, requestURI string
+	}{
+		{"http://example.com/path?q=1", "http://example.com/path?q=1"},
+		{"http://example.com:8080/a%2Fb//c", "http://example.com:8080/a%2Fb//c"},
+		{"http://example.com", "http://example.com/"},
+	}
+	for _, tt := range tests {
+		req := &fasthttp.Request{}
+		req.SetRequestURI(tt.uri)
+		resp := &fasthttp.Response{}
+		if err := client.DoTimeout(req, resp, 5*time.Second); err != nil {
+			t.Fatalf("%s: %v", tt.uri, err)
+		}
+		if string(resp.Body()) != "ok" {
+			t.Errorf("%s: body %q", tt.uri, resp.Body())
+		}
+		select {
+		case got := <-requests:
+			if got.RequestURI != tt.requestURI {
+				t.Errorf("%s: upstream got %s, want %s", tt.uri, got.RequestURI, tt.requestURI)
+			}
+			if auth := got.Header.Get("Proxy-Authorization"); auth != basicAuth("user:pass") {
+				t.Errorf("%s: Proxy-Authorization %q", tt.uri, auth)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: nothing reached the upstream", tt.uri)
+		}
+		if h := req.Header.Peek("Proxy-Authorization"); h != nil {
+			t.Errorf("%s: Proxy-Authorization left on the request", tt.uri)
+		}
+	}
+}
+
+func TestHTTPProxyDialerConnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sent := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		var head string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			head += line
+			if line == "\r\n" {
+				break
+			}
+		}
+		sent <- head
+		// the first tunnel bytes come with the response
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello"))
+	}()
+
+	dial, err := httpProxyDialer("http://user:pass@" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	want := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: " + basicAuth("user:pass") + "\r\n\r\n"
+	if got := <-sent; got != want {
+		t.Errorf("sent upstream %q, want %q", got, want)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, 5)
+	if _, err = io.ReadFull(c, got); err != nil || string(got) != "hello" {
+		t.Errorf("tunnel read %q, %v", got, err)
+	}
+}
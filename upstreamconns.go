@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var idleConnReaperInterval = flag.Duration(`idle-conn-reaper-interval`, 0, `Also close outbound HTTP keep-alive connections idle longer than -max-idle-conn-duration at this interval (0: leave it to the client)`)
+
+var (
+	metricUpstreamConns  = newGauge("proxy_upstream_conns", "Open outbound connections of forwarded HTTP requests")
+	metricUpstreamActive = newGauge("proxy_upstream_conns_active", "Forwarded HTTP requests in flight, the rest of proxy_upstream_conns are idle")
+)
+
+// upstreamConn is an outbound HTTP client conn, counted per host and reaped once idle too long
+type upstreamConn struct {
+	net.Conn
+	host    string
+	lastUse atomic.Int64
+	once    sync.Once
+	// while a response is streamed, each read may wait this long past the request timeout
+	streamIdle atomic.Int64
+}
+
+func (c *upstreamConn) Read(p []byte) (int, error) {
+	now := time.Now()
+	c.lastUse.Store(now.UnixNano())
+	if idle := c.streamIdle.Load(); idle > 0 {
+		c.Conn.SetReadDeadline(now.Add(time.Duration(idle)))
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *upstreamConn) Write(p []byte) (int, error) {
+	c.lastUse.Store(time.Now().UnixNano())
+	return c.Conn.Write(p)
+}
+
+func (c *upstreamConn) Close() error {
+	c.once.Do(func() {
+		upstreamConns.Lock()
+		delete(upstreamConns.conns, c)
+		upstreamConns.hosts[c.host].open--
+		upstreamConns.forget(c.host)
+		upstreamConns.Unlock()
+		metricUpstreamConns.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+type upstreamHost struct {
+	open, active int
+}
+
+type upstreamTracker struct {
+	sync.Mutex
+	conns map[*upstreamConn]struct{}
+	hosts map[string]*upstreamHost
+}
+
+var upstreamConns = &upstreamTracker{conns: make(map[*upstreamConn]struct{}), hosts: make(map[string]*upstreamHost)}
+
+// host returns the counts of host, adding them. Callers hold the lock.
+func (u *upstreamTracker) host(host string) *upstreamHost {
+	h := u.hosts[host]
+	if h == nil {
+		h = &upstreamHost{}
+		u.hosts[host] = h
+	}
+	return h
+}
+
+// forget drops the counts of host once nothing uses it. Callers hold the lock.
+func (u *upstreamTracker) forget(host string) {
+	if h := u.hosts[host]; h.open <= 0 && h.active <= 0 {
+		delete(u.hosts, host)
+	}
+}
+
+// trackUpstreamConn counts c as an open conn to host
+func trackUpstreamConn(c net.Conn, host string) net.Conn {
+	uc := &upstreamConn{Conn: c, host: host}
+	uc.lastUse.Store(time.Now().UnixNano())
+	upstreamConns.Lock()
+	upstreamConns.conns[uc] = struct{}{}
+	upstreamConns.host(host).open++
+	upstreamConns.Unlock()
+	metricUpstreamConns.Add(1)
+	return uc
+}
+
+// upstreamRequest counts a forwarded request to host as in flight until the returned func is called
+func upstreamRequest(host string) func() {
+	upstreamConns.Lock()
+	upstreamConns.host(host).active++
+	upstreamConns.Unlock()
+	metricUpstreamActive.Add(1)
+	return func() {
+		upstreamConns.Lock()
+		upstreamConns.hosts[host].active--
+		upstreamConns.forget(host)
+		upstreamConns.Unlock()
+		metricUpstreamActive.Add(-1)
+	}
+}
+
+type upstreamConnStats struct {
+	Idle   int `json:"idle"`
+	Active int `json:"active"`
+}
+
+// snapshotUpstreamConns returns the idle and active conns per host
+func snapshotUpstreamConns() map[string]upstreamConnStats {
+	upstreamConns.Lock()
+	defer upstreamConns.Unlock()
+	st := make(map[string]upstreamConnStats, len(upstreamConns.hosts))
+	for name, h := range upstreamConns.hosts {
+		// a request may be in flight before its conn is dialed
+		active := h.active
+		if active > h.open {
+			active = h.open
+		}
+		st[name] = upstreamConnStats{Idle: h.open - active, Active: active}
+	}
+	return st
+}
+
+// reapIdleConns closes outbound conns with no reads or writes for maxIdle, every interval
+func reapIdleConns(interval, maxIdle, readTimeout time.Duration) {
+	for now := range time.Tick(interval) {
+		idle := idleUpstreamConns(now, maxIdle, readTimeout)
+		for _, c := range idle {
+			c.Close()
+		}
+		if len(idle) != 0 {
+			log.Println("Reaped idle upstream conns:", len(idle))
+		}
+	}
+}
+
+// idleUpstreamConns returns the outbound conns with no reads or writes for maxIdle at now.
+// Conns of a host with requests in flight may be waiting for a response, those are
+// only idle once silent longer than the client's ReadTimeout too.
+func idleUpstreamConns(now time.Time, maxIdle, readTimeout time.Duration) []*upstreamConn {
+	busyIdle := maxIdle
+	if busyIdle < readTimeout {
+		busyIdle = readTimeout
+	}
+	var idle []*upstreamConn
+	upstreamConns.Lock()
+	defer upstreamConns.Unlock()
+	for c := range upstreamConns.conns {
+		limit := maxIdle
+		if upstreamConns.hosts[c.host].active > 0 {
+			limit = busyIdle
+		}
+		if now.Sub(time.Unix(0, c.lastUse.Load())) > limit {
+			idle = append(idle, c)
+		}
+	}
+	return idle
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleUpstreamConns(t *testing.T) {
+	var conns []net.Conn
+	track := func(host string) *upstreamConn {
+		a, b := net.Pipe()
+		conns = append(conns, a, b)
+		return trackUpstreamConn(a, host).(*upstreamConn)
+	}
+	idleHost := track("idle.example:80")
+	busyHost := track("busy.example:80")
+	done := upstreamRequest("busy.example:80")
+	defer func() {
+		done()
+		idleHost.Close()
+		busyHost.Close()
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	start := time.Now()
+	idleHost.lastUse.Store(start.UnixNano())
+	busyHost.lastUse.Store(start.UnixNano())
+	maxIdle, readTimeout := time.Minute, 5*time.Minute
+
+	reaped := func(now time.Time) map[*upstreamConn]bool {
+		m := map[*upstreamConn]bool{}
+		for _, c := range idleUpstreamConns(now, maxIdle, readTimeout) {
+			m[c] = true
+		}
+		return m
+	}
+	if r := reaped(start.Add(30 * time.Second)); len(r) != 0 {
+		t.Errorf("reaped %d conns before -max-idle-conn-duration", len(r))
+	}
+	// a host with a request in flight waits for the response up to the read timeout
+	if r := reaped(start.Add(2 * time.Minute)); !r[idleHost] || r[busyHost] {
+		t.Errorf("after maxIdle: idle reaped %v, busy reaped %v; want true, false", r[idleHost], r[busyHost])
+	}
+	if r := reaped(start.Add(6 * time.Minute)); !r[idleHost] || !r[busyHost] {
+		t.Errorf("after readTimeout: idle reaped %v, busy reaped %v; want true, true", r[idleHost], r[busyHost])
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var upstreamList = flag.String(`upstreams`, ``, `Spread outbound connections over upstream proxies by weight, comma separated url@weight. Eg: http://10.0.0.1:3128@3,socks5://10.0.0.2:1080@1. One that can not be reached is left out for a while`)
+
+// how long an upstream that could not be reached is left out of the rotation
+const upstreamDownTime = 30 * time.Second
+
+type poolUpstream struct {
+	url       string
+	dial      func(network, address string) (net.Conn, error)
+	weight    int
+	current   int
+	downUntil time.Time
+}
+
+// upstreamPool picks upstreams by smooth weighted round-robin
+type upstreamPool struct {
+	sync.Mutex
+	upstreams []*poolUpstream
+}
+
+func parseUpstreamPool(spec string) (*upstreamPool, error) {
+	p := &upstreamPool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		u := &poolUpstream{url: part, weight: 1}
+		// user:pass@ may come before the weight
+		if i := strings.LastIndexByte(part, '@'); i >= 0 {
+			if w, err := strconv.Atoi(part[i+1:]); err == nil {
+				if w <= 0 {
+					return nil, fmt.Errorf("invalid upstream weight: %q", part)
+				}
+				u.url, u.weight = part[:i], w
+			}
+		}
+		var err error
+		if u.dial, err = proxyURLDialer(u.url); err != nil {
+			return nil, err
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+	if len(p.upstreams) == 0 {
+		return nil, errors.New("no upstreams")
+	}
+	return p, nil
+}
+
+// pick returns the next upstream not in skip, preferring ones not down
+func (p *upstreamPool) pick(skip map[*poolUpstream]bool) *poolUpstream {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	for _, includeDown := range []bool{false, true} {
+		var best *poolUpstream
+		total := 0
+		for _, u := range p.upstreams {
+			if skip[u] || (!includeDown && now.Before(u.downUntil)) {
+				continue
+			}
+			u.current += u.weight
+			total += u.weight
+			if best == nil || u.current > best.current {
+				best = u
+			}
+		}
+		if best != nil {
+			best.current -= total
+			return best
+		}
+	}
+	return nil
+}
+
+func (p *upstreamPool) markDown(u *poolUpstream, err error) {
+	p.Lock()
+	defer p.Unlock()
+	if time.Now().After(u.downUntil) {
+		log.Println("Upstream down:", u.url, err)
+	}
+	u.downUntil = time.Now().Add(upstreamDownTime)
+}
+
+func (p *upstreamPool) markUp(u *poolUpstream) {
+	p.Lock()
+	defer p.Unlock()
+	if !u.downUntil.IsZero() {
+		log.Println("Upstream up:", u.url)
+		u.downUntil = time.Time{}
+	}
+}
+
+// unreachable tells an upstream that could not be reached from one that refused the destination
+func unreachable(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dial dials address through the next upstream, trying the others when it can not be reached
+func (p *upstreamPool) dial(network, address string) (net.Conn, error) {
+	tried := make(map[*poolUpstream]bool)
+	var err error
+	for u := p.pick(tried); u != nil; u = p.pick(tried) {
+		var c net.Conn
+		if c, err = u.dial(network, address); err == nil {
+			p.markUp(u)
+			return c, nil
+		}
+		if !unreachable(err) {
+			return nil, err
+		}
+		p.markDown(u, err)
+		tried[u] = true
+	}
+	return nil, err
+}
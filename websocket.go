@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// isUpgrade reports whether a request asks to switch protocols, eg: to websocket
+func isUpgrade(h *fasthttp.RequestHeader) bool {
+	return h.ConnectionUpgrade() && len(h.Peek("Upgrade")) != 0
+}
+
+// upgradeHandler forwards an upgrade request to remoteAddr as is, then splices
+// the raw connections so the origin answers the handshake and the stream that follows
+func upgradeHandler(ctx *fasthttp.RequestCtx, dial func(network, address string) (net.Conn, error), remoteAddr string, al *accessLog) error {
+	if err := acquireTunnel(); err != nil {
+		return err
+	}
+	r, err := dial("tcp", remoteAddr)
+	if err != nil {
+		releaseTunnel()
+		metricDialErrors.Add(1)
+		return err
+	}
+
+	// Connection and Upgrade are the handshake itself, only drop the proxy's own headers
+	ctx.Request.Header.Del("Proxy-Authorization")
+	ctx.Request.Header.Del("Proxy-Connection")
+	if *forwardedFor {
+		addForwardedHeaders(&ctx.Request.Header, ctx.RemoteIP().String())
+	}
+	rewriteHeaders(&ctx.Request.Header)
+	w := bufio.NewWriter(r)
+	if err = ctx.Request.Write(w); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		releaseTunnel()
+		r.Close()
+		return err
+	}
+
+	al.Status = fasthttp.StatusSwitchingProtocols
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(clientConn net.Conn) {
+		defer releaseTunnel()
+		relay(clientConn, r, remoteAddr, al)
+	})
+	return nil
+}